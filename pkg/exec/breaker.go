@@ -0,0 +1,118 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// ErrCircuitOpen is returned without contacting the pod while its circuit
+// breaker is open, see CircuitBreakerThreshold.
+var ErrCircuitOpen = fmt.Errorf("circuit breaker open")
+
+// defaultCircuitBreakerCooldown is used when CircuitBreakerCooldown is unset.
+const defaultCircuitBreakerCooldown = 30 * time.Second
+
+// circuitBreaker tracks the consecutive exec failures of one pod. After
+// threshold of them it opens and rejects execs until cooldown has passed.
+// Then a single exec is let through as a probe: success closes the breaker
+// again, failure reopens it for another cooldown.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	pod       string
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow reports whether an exec may go ahead.
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return nil
+	}
+	if wait := time.Until(b.openUntil); wait > 0 {
+		return fmt.Errorf("%w for pod %s, retry in %s", ErrCircuitOpen, b.pod, wait.Round(time.Second))
+	}
+	if b.probing {
+		return fmt.Errorf("%w for pod %s, a probe exec is in flight", ErrCircuitOpen, b.pod)
+	}
+	b.probing = true
+	return nil
+}
+
+// record counts the outcome of an exec allowed by allow. Commands exiting
+// with a non-zero status don't count as failures: the pod served them fine.
+// Execs the caller canceled count neither way. A nil breaker ignores it.
+func (b *circuitBreaker) record(err error, canceled bool) {
+	if b == nil {
+		return
+	}
+	if _, ok := exitCode(err); ok {
+		err = nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+	if canceled {
+		return
+	}
+	if err == nil {
+		if b.failures >= b.threshold {
+			log.Info("circuit breaker closed", zap.String("pod", b.pod))
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+		log.Warn("circuit breaker open", zap.String("pod", b.pod), zap.Int("failures", b.failures), zap.String("cooldown", b.cooldown.String()), zap.Error(err))
+	}
+}
+
+// circuitBreaker returns the breaker of the target pod, keyed by its UID so a
+// replacement pod starts with a closed one, after checking that it allows an
+// exec. It returns nil when CircuitBreakerThreshold is unset.
+func (c *Client) circuitBreaker(ctx context.Context) (*circuitBreaker, error) {
+	if c.CircuitBreakerThreshold <= 0 {
+		return nil, nil
+	}
+	uid, err := c.podUID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	name, err := c.podName(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cooldown := c.CircuitBreakerCooldown
+	if cooldown <= 0 {
+		cooldown = defaultCircuitBreakerCooldown
+	}
+
+	c.mu.Lock()
+	if c.breakers == nil {
+		c.breakers = make(map[string]*circuitBreaker)
+	}
+	b, ok := c.breakers[uid]
+	if !ok {
+		b = &circuitBreaker{pod: c.Namespace + "/" + name, threshold: c.CircuitBreakerThreshold, cooldown: cooldown}
+		c.breakers[uid] = b
+	}
+	c.mu.Unlock()
+
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
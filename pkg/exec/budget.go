@@ -0,0 +1,34 @@
+package exec
+
+import (
+	"context"
+	"time"
+)
+
+// ExecBudget returns a context that expires total from now, so that several
+// execs run under it share one overall deadline instead of each getting its
+// own timeout. Every exec run with the returned context gets only the time
+// that is left; see RemainingTimeout.
+func ExecBudget(ctx context.Context, total time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, total)
+}
+
+// RemainingTimeout returns how long an operation under ctx may still run:
+// the time left until ctx's deadline, capped at timeout when timeout is
+// positive. Without a deadline it returns timeout unchanged. A context past
+// its deadline yields a tiny positive duration so callers fail fast rather
+// than treating it as "no timeout".
+func RemainingTimeout(ctx context.Context, timeout time.Duration) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return timeout
+	}
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		remaining = time.Millisecond
+	}
+	if timeout > 0 && timeout < remaining {
+		return timeout
+	}
+	return remaining
+}
@@ -0,0 +1,157 @@
+package exec
+
+import (
+	"fmt"
+	"strings"
+)
+
+// redirectOps are the redirections CommandBuilder.Redirect accepts.
+var redirectOps = map[string]bool{
+	"<":    true,
+	">":    true,
+	">>":   true,
+	"2>":   true,
+	"2>>":  true,
+	"2>&1": true,
+}
+
+// CommandBuilder composes a command from separate arguments, so values from
+// users or configuration never need to be quoted by hand. A command without
+// pipes or redirections is run directly; otherwise it is run with sh -c and
+// every argument is quoted for the shell:
+//
+//	cmd, err := NewCommand("grep").Flag("-m", "5").Arg(pattern).Arg(file).
+//		Pipe().Arg("sort").Redirect(">", out).Build()
+//	err = c.ExecPod(cmd, nil, stdout, stderr, false, time.Minute)
+type CommandBuilder struct {
+	// stages are the finished commands of a pipeline before words.
+	stages [][]word
+	words  []word
+	shell  bool
+	err    error
+}
+
+// word is an argument, or a redirection operator when op is set. Operators
+// are the only words left unquoted in the shell script.
+type word struct {
+	text string
+	op   bool
+}
+
+// NewCommand starts a command running name.
+func NewCommand(name string) *CommandBuilder {
+	return (&CommandBuilder{}).Arg(name)
+}
+
+// Arg appends an argument.
+func (b *CommandBuilder) Arg(arg string) *CommandBuilder {
+	b.words = append(b.words, word{text: arg})
+	return b
+}
+
+// Args appends several arguments.
+func (b *CommandBuilder) Args(args ...string) *CommandBuilder {
+	for _, arg := range args {
+		b.Arg(arg)
+	}
+	return b
+}
+
+// Flag appends name followed by value as a separate argument, or only name
+// when value is empty.
+func (b *CommandBuilder) Flag(name, value string) *CommandBuilder {
+	b.Arg(name)
+	if value != "" {
+		b.Arg(value)
+	}
+	return b
+}
+
+// Pipe ends the current command and pipes its stdout into the one the
+// following arguments make up.
+func (b *CommandBuilder) Pipe() *CommandBuilder {
+	if len(b.words) == 0 {
+		b.fail(fmt.Errorf("pipe without a command before it"))
+		return b
+	}
+	b.shell = true
+	b.stages = append(b.stages, b.words)
+	b.words = nil
+	return b
+}
+
+// Redirect redirects a stream of the current command, op being one of "<",
+// ">", ">>", "2>", "2>>" and "2>&1". target is ignored for "2>&1".
+func (b *CommandBuilder) Redirect(op, target string) *CommandBuilder {
+	if !redirectOps[op] {
+		b.fail(fmt.Errorf("unsupported redirection %q", op))
+		return b
+	}
+	if len(b.words) == 0 {
+		b.fail(fmt.Errorf("redirection %q without a command", op))
+		return b
+	}
+	b.shell = true
+	b.words = append(b.words, word{text: op, op: true})
+	if op != "2>&1" {
+		b.Arg(target)
+	}
+	return b
+}
+
+func (b *CommandBuilder) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+// Shell returns the command as a script for sh -c, with every argument
+// quoted.
+func (b *CommandBuilder) Shell() (string, error) {
+	if b.err != nil {
+		return "", b.err
+	}
+	if len(b.words) == 0 {
+		return "", fmt.Errorf("empty command")
+	}
+
+	all := make([][]word, 0, len(b.stages)+1)
+	all = append(append(all, b.stages...), b.words)
+	stages := make([]string, 0, len(all))
+	for _, words := range all {
+		quoted := make([]string, len(words))
+		for i, w := range words {
+			if w.op {
+				quoted[i] = w.text
+			} else {
+				quoted[i] = shellQuote(w.text)
+			}
+		}
+		stages = append(stages, strings.Join(quoted, " "))
+	}
+	return strings.Join(stages, " | "), nil
+}
+
+// Build returns the command for ExecPod and friends: the plain argument list
+// when no shell features are used, and sh -c with the quoted script
+// otherwise.
+func (b *CommandBuilder) Build() ([]string, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if !b.shell {
+		if len(b.words) == 0 {
+			return nil, fmt.Errorf("empty command")
+		}
+		command := make([]string, len(b.words))
+		for i, w := range b.words {
+			command[i] = w.text
+		}
+		return command, nil
+	}
+	script, err := b.Shell()
+	if err != nil {
+		return nil, err
+	}
+	return shellCommand(script), nil
+}
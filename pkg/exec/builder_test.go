@@ -0,0 +1,113 @@
+package exec
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCommandBuilderDirect(t *testing.T) {
+	got, err := NewCommand("grep").Flag("-m", "5").Flag("-i", "").Arg("it's a pattern").Args("a file", "$HOME").Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"grep", "-m", "5", "-i", "it's a pattern", "a file", "$HOME"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Build() = %q, want %q", got, want)
+	}
+}
+
+func TestCommandBuilderShell(t *testing.T) {
+	tests := []struct {
+		name string
+		b    *CommandBuilder
+		want string
+	}{
+		{
+			"pipe",
+			NewCommand("grep").Arg("a b").Pipe().Arg("sort").Flag("-k", "2"),
+			`grep 'a b' | sort -k 2`,
+		},
+		{
+			"redirections",
+			NewCommand("cmd").Redirect("<", "in file").Redirect(">>", "out's").Redirect("2>&1", ""),
+			`cmd < 'in file' >> 'out'\''s' 2>&1`,
+		},
+		{
+			"operators in arguments stay quoted",
+			NewCommand("echo").Arg("|").Arg(">").Arg("2>&1").Pipe().Arg("cat"),
+			`echo '|' '>' '2>&1' | cat`,
+		},
+		{
+			"NUL prefixed argument",
+			NewCommand("echo").Arg("\x00; touch /tmp/pwned").Pipe().Arg("cat"),
+			"echo '\x00; touch /tmp/pwned' | cat",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.b.Shell()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Shell() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandBuilderErrors(t *testing.T) {
+	for name, b := range map[string]*CommandBuilder{
+		"pipe first":          (&CommandBuilder{}).Pipe().Arg("cat"),
+		"unknown redirection": NewCommand("cat").Redirect("&>", "out"),
+		"trailing pipe":       NewCommand("cat").Pipe(),
+	} {
+		if _, err := b.Build(); err == nil {
+			t.Errorf("%s: Build() succeeded", name)
+		}
+	}
+}
+
+func TestCommandBuilderInjection(t *testing.T) {
+	c, _ := newTestClient(t, ClientOpt{})
+	dir := t.TempDir()
+	pwned := filepath.Join(dir, "pwned")
+
+	for _, value := range injectionAttempts(pwned) {
+		command, err := NewCommand("printf").Arg("%s").Arg(value).Pipe().Arg("cat").Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var stdout bytes.Buffer
+		if err := c.ExecPod(command, nil, &stdout, nil, false, time.Minute); err != nil {
+			t.Fatalf("value %q: %v", value, err)
+		}
+		if stdout.String() != value {
+			t.Errorf("value %q came out as %q", value, stdout.String())
+		}
+		if _, err := os.Stat(pwned); err == nil {
+			t.Fatalf("value %q ran an injected command", value)
+		}
+	}
+
+	// Redirection targets are quoted as well.
+	target := filepath.Join(dir, "out; echo injected >&2")
+	command, err := NewCommand("echo").Arg("hi").Redirect(">", target).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stderr bytes.Buffer
+	if err := c.ExecPod(command, nil, nil, &stderr, false, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(target); err != nil || string(data) != "hi\n" {
+		t.Errorf("redirection target holds %q, %v; want \"hi\\n\"", data, err)
+	}
+	if stderr.Len() > 0 {
+		t.Errorf("redirection target ran an injected command: %q", stderr.String())
+	}
+}
@@ -0,0 +1,126 @@
+package exec
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ttlCache is a small map whose entries expire ttl after they were set.
+type ttlCache struct {
+	mu      sync.Mutex
+	entries map[string]ttlEntry
+}
+
+type ttlEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func (c *ttlCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (c *ttlCache) set(key string, value interface{}, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]ttlEntry)
+	}
+	c.entries[key] = ttlEntry{value: value, expires: time.Now().Add(ttl)}
+}
+
+// deleteStale drops the entries whose key starts with prefix but not with
+// keep.
+func (c *ttlCache) deleteStale(prefix, keep string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.entries {
+		if strings.HasPrefix(key, prefix) && !strings.HasPrefix(key, keep) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// cachedResult is a successful capture kept by ExecPodCached.
+type cachedResult struct {
+	stdout string
+	stderr string
+}
+
+// podUIDTTL is how long pod UIDs are cached when ResultCacheTTL is unset.
+const podUIDTTL = time.Minute
+
+// podUID returns the UID of the target pod, looked up at most once per
+// ResultCacheTTL, or per minute if that is unset.
+func (c *Client) podUID(ctx context.Context) (string, error) {
+	name, err := c.podName(ctx)
+	if err != nil {
+		return "", err
+	}
+	key := "uid\x00" + c.Namespace + "\x00" + name
+	if uid, ok := c.cache.get(key); ok {
+		return uid.(string), nil
+	}
+
+	pod, err := c.getPod(ctx)
+	if err != nil {
+		return "", err
+	}
+	uid := string(pod.UID)
+	ttl := c.ResultCacheTTL
+	if ttl <= 0 {
+		ttl = podUIDTTL
+	}
+	c.cache.set(key, uid, ttl)
+	return uid, nil
+}
+
+// resultKey is the content-addressed cache key of command run in container
+// of the pod with the given UID.
+func resultKey(uid, container string, command []string) string {
+	sum := sha256.Sum256([]byte(container + "\x00" + strings.Join(command, "\x00")))
+	return "result\x00" + uid + "\x00" + hex.EncodeToString(sum[:])
+}
+
+// ExecPodCached is like a plain capture of command, but successful results
+// are kept for ResultCacheTTL and returned without another exec while fresh.
+// Only use it for read-only commands whose output may be stale for that long.
+// Results are keyed by pod UID, container and command, so a replaced pod
+// never serves another pod's results. A zero ResultCacheTTL disables caching.
+func (c *Client) ExecPodCached(ctx context.Context, command []string, timeout time.Duration) (stdout, stderr string, err error) {
+	if c.ResultCacheTTL <= 0 {
+		return c.captureExplained(ctx, command, timeout)
+	}
+
+	uid, err := c.podUID(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	key := resultKey(uid, c.ContainerName, command)
+	if result, ok := c.cache.get(key); ok {
+		r := result.(cachedResult)
+		return r.stdout, r.stderr, nil
+	}
+	c.cache.deleteStale("result\x00", "result\x00"+uid+"\x00")
+
+	stdout, stderr, err = c.captureExplained(ctx, command, timeout)
+	if err != nil {
+		return stdout, stderr, err
+	}
+	c.cache.set(key, cachedResult{stdout: stdout, stderr: stderr}, c.ResultCacheTTL)
+	return stdout, stderr, nil
+}
@@ -0,0 +1,280 @@
+package exec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// capture runs command without stdin or a TTY and returns what it wrote to
+// stdout and stderr, each cut off at MaxCaptureBytes and decoded from
+// OutputEncoding.
+func (c *Client) capture(ctx context.Context, command []string, timeout time.Duration) (stdout, stderr string, err error) {
+	return c.captureWith(ctx, execOptions{command: command, timeout: timeout})
+}
+
+// captureExplained is capture for commands the caller asked to run: unlike
+// internal probes, their failures are explained under ExplainOnFailure.
+func (c *Client) captureExplained(ctx context.Context, command []string, timeout time.Duration) (stdout, stderr string, err error) {
+	return c.captureWith(ctx, execOptions{command: command, timeout: timeout, explain: true})
+}
+
+func (c *Client) captureWith(ctx context.Context, opts execOptions) (stdout, stderr string, err error) {
+	outBuf := &limitedBuffer{limit: c.MaxCaptureBytes}
+	errBuf := &limitedBuffer{limit: c.MaxCaptureBytes}
+	opts.stdout, opts.stderr = outBuf, errBuf
+	err = c.exec(ctx, opts)
+	return c.decodeOutput(outBuf.Bytes()), c.decodeOutput(errBuf.Bytes()), err
+}
+
+// decodeOutput converts captured output from OutputEncoding to UTF-8. Bytes
+// that can't be decoded are replaced rather than failing the capture.
+func (c *Client) decodeOutput(b []byte) string {
+	if c.OutputEncoding == nil {
+		return string(b)
+	}
+	decoded, err := c.OutputEncoding.NewDecoder().Bytes(b)
+	if err != nil {
+		return strings.ToValidUTF8(string(b), "\uFFFD")
+	}
+	return string(decoded)
+}
+
+// limitedBuffer is a bytes.Buffer that silently drops everything past limit
+// bytes. A limit of zero or less means no limit.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if b.limit > 0 {
+		if room := b.limit - b.Len(); len(p) > room {
+			b.Buffer.Write(p[:room])
+			return len(p), nil
+		}
+	}
+	return b.Buffer.Write(p)
+}
+
+// withStderr annotates err with the command's stderr, if there is any.
+func withStderr(err error, stderr string) error {
+	if stderr = strings.TrimSpace(stderr); stderr != "" {
+		return fmt.Errorf("%w: %s", err, stderr)
+	}
+	return err
+}
+
+// ExecPodSplit runs command and splits its stdout on sep, e.g. "\n", "\x00" or
+// ",". The trailing newline is trimmed and the empty element produced by a
+// trailing separator is dropped.
+func (c *Client) ExecPodSplit(command []string, sep string, timeout time.Duration) ([]string, error) {
+	stdout, stderr, err := c.captureExplained(context.Background(), command, timeout)
+	if err != nil {
+		return nil, withStderr(err, stderr)
+	}
+
+	stdout = strings.TrimSuffix(stdout, "\n")
+	if stdout == "" {
+		return nil, nil
+	}
+	parts := strings.Split(stdout, sep)
+	if parts[len(parts)-1] == "" {
+		parts = parts[:len(parts)-1]
+	}
+	return parts, nil
+}
+
+// cleanupTimeout bounds the cleanup command of ExecPodCleanup independently of
+// the main command's timeout.
+const cleanupTimeout = 15 * time.Second
+
+// ExecPodCleanup runs command and then always runs cleanup in a fresh exec,
+// even if command failed or timed out. If both fail, the errors are joined.
+func (c *Client) ExecPodCleanup(command []string, cleanup []string, timeout time.Duration) error {
+	_, stderr, err := c.captureExplained(context.Background(), command, timeout)
+	if err != nil {
+		err = withStderr(err, stderr)
+	}
+
+	_, cleanupStderr, cleanupErr := c.captureExplained(context.Background(), cleanup, cleanupTimeout)
+	if cleanupErr != nil {
+		cleanupErr = fmt.Errorf("cleanup failed: %w", withStderr(cleanupErr, cleanupStderr))
+	}
+
+	return errors.Join(err, cleanupErr)
+}
+
+// ExecPodEnvDump returns the environment of the target container by running
+// env, or printenv if env is unavailable. Lines are split on the first "="
+// only; lines without one, such as continuation lines of multi-line values,
+// are skipped.
+func (c *Client) ExecPodEnvDump(timeout time.Duration) (map[string]string, error) {
+	stdout, _, err := c.capture(context.Background(), []string{"env"}, timeout)
+	if err != nil {
+		var stderr string
+		stdout, stderr, err = c.capture(context.Background(), []string{"printenv"}, timeout)
+		if err != nil {
+			return nil, withStderr(err, stderr)
+		}
+	}
+
+	env := make(map[string]string)
+	for _, line := range strings.Split(stdout, "\n") {
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || key == "" || strings.ContainsAny(key, " \t") {
+			continue
+		}
+		env[key] = value
+	}
+	return env, nil
+}
+
+// ExecPodMatch runs command and matches re against its stdout, returning the
+// whole match followed by the submatches as regexp.FindStringSubmatch does.
+// If nothing matches, the error includes the output.
+func (c *Client) ExecPodMatch(command []string, re *regexp.Regexp, timeout time.Duration) ([]string, error) {
+	stdout, stderr, err := c.captureExplained(context.Background(), command, timeout)
+	if err != nil {
+		return nil, withStderr(err, stderr)
+	}
+
+	match := re.FindStringSubmatch(stdout)
+	if match == nil {
+		return nil, fmt.Errorf("output of %q does not match %s: %q", strings.Join(command, " "), re, stdout)
+	}
+	return match, nil
+}
+
+// ExecPodToGzipFile runs command and streams its combined stdout and stderr
+// gzip-compressed into a new file at path, e.g. to keep verbose diagnostics
+// for a support bundle without holding them in memory. The gzip stream is
+// closed whatever happens, so after a failed run the file still holds a valid
+// archive of the output received until then.
+func (c *Client) ExecPodToGzipFile(ctx context.Context, command []string, path string) (err error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	gz := gzip.NewWriter(f)
+	defer func() {
+		if closeErr := gz.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to finish %s: %w", path, closeErr)
+		}
+	}()
+
+	out := &syncWriter{w: gz}
+	return c.exec(ctx, execOptions{
+		command: command,
+		explain: true,
+		stdout:  out,
+		stderr:  out,
+	})
+}
+
+// ExecPodTail runs command and returns the last n lines of its stdout, oldest
+// first, or all of them if there are fewer. Only n lines are kept while the
+// command runs, so it suits commands that print a lot of which only the end
+// matters. n must be positive.
+func (c *Client) ExecPodTail(command []string, n int, timeout time.Duration) ([]string, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("invalid line count %d", n)
+	}
+
+	ring := make([]string, n)
+	count := 0
+	lines := newLineWriter(func(line string) error {
+		ring[count%n] = line
+		count++
+		return nil
+	})
+
+	stderr := &limitedBuffer{limit: c.MaxCaptureBytes}
+	err := c.exec(context.Background(), execOptions{
+		command: command,
+		explain: true,
+		stdout:  lines,
+		stderr:  stderr,
+		timeout: timeout,
+	})
+	lines.Flush()
+
+	tail := make([]string, 0, n)
+	start := 0
+	if count > n {
+		start = count - n
+	}
+	for i := start; i < count; i++ {
+		tail = append(tail, c.decodeOutput([]byte(ring[i%n])))
+	}
+	if err != nil {
+		return tail, withStderr(err, c.decodeOutput(stderr.Bytes()))
+	}
+	return tail, nil
+}
+
+// firstByteWriter forwards to w and records when the first byte arrived
+// through any firstByteWriter sharing once and at.
+type firstByteWriter struct {
+	w    io.Writer
+	once *sync.Once
+	at   *time.Time
+}
+
+func (f firstByteWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		f.once.Do(func() { *f.at = time.Now() })
+	}
+	return f.w.Write(p)
+}
+
+// ExecPodTTFB runs command and returns how long it took until its first byte
+// of output, on stdout or stderr, arrived, along with the total duration and
+// its stdout. Both are measured from just before the exec request is sent,
+// so they include connection setup. A command without any output gets a zero
+// ttfb, which is logged.
+func (c *Client) ExecPodTTFB(command []string, timeout time.Duration) (ttfb time.Duration, total time.Duration, output string, err error) {
+	var once sync.Once
+	var first time.Time
+	outBuf := &limitedBuffer{limit: c.MaxCaptureBytes}
+	errBuf := &limitedBuffer{limit: c.MaxCaptureBytes}
+
+	start := time.Now()
+	err = c.exec(context.Background(), execOptions{
+		command: command,
+		explain: true,
+		stdout:  firstByteWriter{w: outBuf, once: &once, at: &first},
+		stderr:  firstByteWriter{w: errBuf, once: &once, at: &first},
+		timeout: timeout,
+	})
+	total = time.Since(start)
+
+	if first.IsZero() {
+		log.Info("command produced no output, time to first byte is zero", zap.Strings("command", command))
+	} else {
+		ttfb = first.Sub(start)
+	}
+
+	output = c.decodeOutput(outBuf.Bytes())
+	if err != nil {
+		return ttfb, total, output, withStderr(err, c.decodeOutput(errBuf.Bytes()))
+	}
+	return ttfb, total, output, nil
+}
@@ -1,6 +1,10 @@
 package exec
 
 import (
+	"sync"
+	"time"
+
+	"golang.org/x/text/encoding"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -11,6 +15,18 @@ import (
 type Client struct {
 	kubernetes.Interface
 	*ClientOpt
+
+	mu          sync.Mutex
+	resolvedPod string
+
+	// streams holds one token per open exec stream when MaxConcurrentStreams
+	// is set.
+	streams chan struct{}
+
+	cache ttlCache
+
+	// breakers holds the circuit breaker of each pod UID.
+	breakers map[string]*circuitBreaker
 }
 
 type ClientOpt struct {
@@ -21,6 +37,101 @@ type ClientOpt struct {
 	Namespace     string
 
 	CurrentContext string
+
+	// Target locates the pod when PodName is not known up front. It is
+	// resolved with Resolver, or a DefaultPodResolver when Resolver is nil.
+	Target   PodTarget
+	Resolver PodResolver
+
+	// MaxConcurrentStreams caps the number of exec streams the client has open
+	// at once. Further execs wait for a free slot or for their context to be
+	// done. Zero means unlimited.
+	MaxConcurrentStreams int
+
+	// StreamLimiter, when set, additionally caps the streams open at once
+	// across all clients sharing it.
+	StreamLimiter *StreamLimiter
+
+	// MaxCaptureBytes caps how much of stdout and of stderr the capturing
+	// helpers keep; the rest is dropped. Zero means unlimited.
+	MaxCaptureBytes int
+
+	// OutputEncoding is the encoding of the container's output, e.g.
+	// charmap.ISO8859_1 or japanese.ShiftJIS. Helpers returning output as
+	// strings transcode it to UTF-8; helpers handing out raw bytes don't.
+	// nil means the output is already UTF-8.
+	OutputEncoding encoding.Encoding
+
+	// DebugContainer is the ephemeral container used by debugging helpers
+	// such as ResolveProcesses. StartDebugContainer sets it.
+	DebugContainer string
+
+	// Retry controls retrying execs that fail to be set up. The zero value
+	// disables retries.
+	Retry RetryPolicy
+
+	// IgnoreStdinBrokenPipe treats a broken pipe writing the command's stdin
+	// as success when the command exited cleanly. Very short commands can
+	// exit before all of stdin is written to them, which otherwise fails the
+	// exec even though the command ran fine. Stream failures are never
+	// ignored, even when they are a broken pipe of the connection.
+	IgnoreStdinBrokenPipe bool
+
+	// StopOnStdoutClose tears the stream down as soon as writing to stdout
+	// fails, e.g. because the consumer only wanted the first lines and
+	// closed its end, like head does. The exec then succeeds if stdout was
+	// closed and fails with the write error otherwise. This is best effort:
+	// without a TTY the remote command gets no real SIGPIPE, it merely loses
+	// its stream and is stopped by the runtime eventually.
+	StopOnStdoutClose bool
+
+	// ResultCacheTTL is how long ExecPodCached keeps results. Zero disables
+	// the cache.
+	ResultCacheTTL time.Duration
+
+	// Preflight checks exec permissions with a SelfSubjectAccessReview before
+	// every exec. It defaults to PreflightOff.
+	Preflight PreflightMode
+
+	// TerminatingPods decides whether execs into a pod that is being deleted
+	// fail or run with a deadline at the end of its grace period. It
+	// defaults to TerminatingIgnore.
+	TerminatingPods TerminatingPolicy
+
+	// Privilege escalates every command run in the target pod with sudo or
+	// su, for containers that run as an unprivileged user. Escalations are
+	// logged. It defaults to PrivilegeNone.
+	Privilege PrivilegeMode
+
+	// AllowPrivilegedHelpers opts in to helpers that create privileged pods,
+	// such as ExecOnNode.
+	AllowPrivilegedHelpers bool
+
+	// ForceLocale, e.g. "C" or "C.UTF-8", is set as LC_ALL and LANG for every
+	// command, so tools like ls, date and sort print output that doesn't
+	// depend on the container's locale. Empty leaves the locale alone.
+	ForceLocale string
+
+	// NoColor disables ANSI colors in output helpers such as
+	// ExecPodAnnotated, even when writing to a terminal.
+	NoColor bool
+
+	// CircuitBreakerThreshold is the number of consecutive failed execs to a
+	// pod after which further execs to it fail fast with ErrCircuitOpen for
+	// CircuitBreakerCooldown, 30s by default. After the cooldown one exec
+	// probes the pod and closes the breaker again if it succeeds. Zero
+	// disables the breaker.
+	CircuitBreakerThreshold int
+	CircuitBreakerCooldown  time.Duration
+
+	// ExplainOnFailure makes failed execs look up why they failed: exit
+	// status, the end of stderr, the container's state and a suggestion.
+	// The explanation is logged and returned as an *ExplainedError wrapping
+	// the original error. Only the commands the caller runs are explained,
+	// not the probes helpers run internally, such as HasBinary.
+	ExplainOnFailure bool
+
+	TransportOptions
 }
 
 // NewClient returns a new Clientset for the given config.
@@ -30,8 +141,12 @@ func NewClient(opt *ClientOpt) (*Client, error) {
 		return nil, err
 	}
 
-	return &Client{
+	c := &Client{
 		ClientOpt: opt,
 		Interface: k8sClientset,
-	}, nil
+	}
+	if opt.MaxConcurrentStreams > 0 {
+		c.streams = make(chan struct{}, opt.MaxConcurrentStreams)
+	}
+	return c, nil
 }
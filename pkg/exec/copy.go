@@ -0,0 +1,249 @@
+package exec
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// copyTimeout bounds a whole copy into the pod.
+const copyTimeout = 10 * time.Minute
+
+// CopyOptions tune how CopyToPod writes files into the container.
+type CopyOptions struct {
+	// FileMode and DirMode, when set, replace the permission bits of every
+	// copied file and directory, regardless of the local modes and of the
+	// container's umask, e.g. 0600 for secrets. Zero keeps the local modes.
+	FileMode os.FileMode
+	DirMode  os.FileMode
+	// Owner, when set, is passed to chown -R for the copied paths once they
+	// are extracted, e.g. "1000:1000". This needs the exec to run as root.
+	Owner string
+	// CheckSpace checks with df that the destination has room for the files
+	// plus SpaceMargin bytes before copying, failing with
+	// ErrInsufficientSpace otherwise. The check is skipped when the
+	// container has no df.
+	CheckSpace  bool
+	SpaceMargin int64
+	// CreateDestDir creates the destination directory, and its parents, with
+	// mkdir -p when it doesn't exist yet. It is created as the exec user, so
+	// the container's permissions and umask apply.
+	CreateDestDir bool
+}
+
+// ErrInsufficientSpace is returned by CopyToPod when the destination
+// filesystem is too small for the copy.
+var ErrInsufficientSpace = fmt.Errorf("insufficient space")
+
+// CopyToPod copies the local file or directory localPath into remoteDir in
+// the target container, which must exist unless opts.CreateDestDir is set.
+// It streams a tar archive to tar in the container, so the container needs
+// tar and a shell.
+func (c *Client) CopyToPod(ctx context.Context, localPath, remoteDir string, opts CopyOptions) error {
+	localPath = filepath.Clean(localPath)
+	if _, err := os.Lstat(localPath); err != nil {
+		return err
+	}
+	if opts.CreateDestDir {
+		if err := c.createDir(ctx, remoteDir); err != nil {
+			return err
+		}
+	}
+	if opts.CheckSpace {
+		if err := c.checkSpace(ctx, localPath, remoteDir, opts.SpaceMargin); err != nil {
+			return err
+		}
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTar(pw, localPath, opts))
+	}()
+	defer pr.Close()
+
+	// With a umask of 0 the modes in the tar headers are applied as is.
+	script := "umask 0 && tar -xmf - -C " + shellQuote(remoteDir)
+	var stderr bytes.Buffer
+	err := c.exec(ctx, execOptions{
+		command: shellCommand(script),
+		explain: true,
+		stdin:   pr,
+		stderr:  &stderr,
+		timeout: copyTimeout,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy %s to %s: %w", localPath, remoteDir, withStderr(err, stderr.String()))
+	}
+
+	if opts.Owner != "" {
+		target := path.Join(remoteDir, filepath.Base(localPath))
+		_, stderr, err := c.capture(ctx, []string{"chown", "-R", opts.Owner, target}, copyTimeout)
+		if err != nil {
+			return fmt.Errorf("failed to chown %s to %s: %w", target, opts.Owner, withStderr(err, stderr))
+		}
+	}
+	return nil
+}
+
+// writeTar writes localPath, recursively, as a tar archive to w. Entries are
+// named relative to the parent of localPath.
+func writeTar(w io.Writer, localPath string, opts CopyOptions) error {
+	tw := tar.NewWriter(w)
+	base := filepath.Dir(localPath)
+
+	err := filepath.Walk(localPath, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, file)
+		if err != nil {
+			return err
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		}
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		switch {
+		case info.IsDir() && opts.DirMode != 0:
+			header.Mode = int64(opts.DirMode.Perm())
+		case info.Mode().IsRegular() && opts.FileMode != 0:
+			header.Mode = int64(opts.FileMode.Perm())
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// notADirectoryExit is the exit status createDir's script uses when the path
+// exists but is not a directory.
+const notADirectoryExit = 3
+
+// createDir creates dir in the container with mkdir -p, failing clearly if
+// it exists as something other than a directory.
+func (c *Client) createDir(ctx context.Context, dir string) error {
+	quoted := shellQuote(dir)
+	script := fmt.Sprintf("if [ -e %s ] && [ ! -d %s ]; then exit %d; fi; mkdir -p %s", quoted, quoted, notADirectoryExit, quoted)
+	_, stderr, err := c.capture(ctx, shellCommand(script), binaryCheckTimeout)
+	if err != nil {
+		if code, ok := exitCode(err); ok && code == notADirectoryExit {
+			return fmt.Errorf("destination %s exists and is not a directory", dir)
+		}
+		return fmt.Errorf("failed to create %s: %w", dir, withStderr(err, stderr))
+	}
+	return nil
+}
+
+// localSize returns the total size of the regular files under localPath.
+func localSize(localPath string) (int64, error) {
+	var size int64
+	err := filepath.Walk(localPath, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// availableSpace returns the bytes available in the filesystem holding dir,
+// as reported by df -kP.
+func (c *Client) availableSpace(ctx context.Context, dir string) (int64, error) {
+	stdout, stderr, err := c.capture(ctx, []string{"df", "-kP", dir}, binaryCheckTimeout)
+	if err != nil {
+		return 0, withStderr(err, stderr)
+	}
+	return parseDFAvailable(stdout)
+}
+
+// parseDFAvailable parses the available space out of df -kP output. Some df
+// builds wrap a long filesystem name onto its own line, so the data lines are
+// joined first. The columns are then located by Capacity, the first "N%"
+// field following three numbers, since both the filesystem name and the
+// mount point may contain spaces; Available is the field before it.
+func parseDFAvailable(output string) (int64, error) {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) < 2 {
+		return 0, fmt.Errorf("unexpected df output %q", output)
+	}
+	fields := strings.Fields(strings.Join(lines[1:], " "))
+	isNumber := func(s string) bool {
+		_, err := strconv.ParseInt(s, 10, 64)
+		return err == nil
+	}
+	for i := 4; i < len(fields); i++ {
+		capacity := fields[i]
+		if !strings.HasSuffix(capacity, "%") || !isNumber(strings.TrimSuffix(capacity, "%")) {
+			continue
+		}
+		if !isNumber(fields[i-3]) || !isNumber(fields[i-2]) || !isNumber(fields[i-1]) {
+			continue
+		}
+		kb, _ := strconv.ParseInt(fields[i-1], 10, 64)
+		return kb * 1024, nil
+	}
+	return 0, fmt.Errorf("unexpected df output %q", output)
+}
+
+// checkSpace fails with ErrInsufficientSpace when remoteDir lacks room for
+// localPath plus margin bytes.
+func (c *Client) checkSpace(ctx context.Context, localPath, remoteDir string, margin int64) error {
+	ok, err := c.HasBinary(ctx, "df")
+	if err != nil {
+		return fmt.Errorf("failed to check for df: %w", err)
+	}
+	if !ok {
+		log.Warn("df is not available, skipping the disk space check", zap.String("dir", remoteDir))
+		return nil
+	}
+
+	need, err := localSize(localPath)
+	if err != nil {
+		return err
+	}
+	available, err := c.availableSpace(ctx, remoteDir)
+	if err != nil {
+		return fmt.Errorf("failed to check space in %s: %w", remoteDir, err)
+	}
+	if need+margin > available {
+		return fmt.Errorf("%w in %s: need %d bytes plus a margin of %d, %d available", ErrInsufficientSpace, remoteDir, need, margin, available)
+	}
+	return nil
+}
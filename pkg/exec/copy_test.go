@@ -0,0 +1,116 @@
+package exec
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyToPodPreservesModes(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar is not available")
+	}
+	c, _ := newTestClient(t, ClientOpt{})
+
+	src := filepath.Join(t.TempDir(), "src")
+	files := map[string]os.FileMode{
+		"script.sh":        0755,
+		"config":           0640,
+		"sub/secret":       0600,
+		"sub/deeper/plain": 0644,
+	}
+	dirs := map[string]os.FileMode{
+		"":           0755,
+		"sub":        0750,
+		"sub/deeper": 0700,
+	}
+	for name := range files {
+		p := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Set modes explicitly so the local umask doesn't matter.
+	for name, mode := range files {
+		if err := os.Chmod(filepath.Join(src, name), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, mode := range dirs {
+		if err := os.Chmod(filepath.Join(src, name), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(t *testing.T, dest string, fileMode, dirMode func(name string) os.FileMode) {
+		t.Helper()
+		for name := range files {
+			info, err := os.Stat(filepath.Join(dest, "src", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := info.Mode().Perm(), fileMode(name); got != want {
+				t.Errorf("%s has mode %v, want %v", name, got, want)
+			}
+		}
+		for name := range dirs {
+			info, err := os.Stat(filepath.Join(dest, "src", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := info.Mode().Perm(), dirMode(name); got != want {
+				t.Errorf("directory %q has mode %v, want %v", name, got, want)
+			}
+		}
+	}
+
+	t.Run("local modes", func(t *testing.T) {
+		dest := t.TempDir()
+		if err := c.CopyToPod(context.Background(), src, dest, CopyOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		check(t, dest,
+			func(name string) os.FileMode { return files[name] },
+			func(name string) os.FileMode { return dirs[name] })
+	})
+
+	t.Run("forced modes", func(t *testing.T) {
+		dest := filepath.Join(t.TempDir(), "missing", "dest")
+		opts := CopyOptions{FileMode: 0600, DirMode: 0711, CreateDestDir: true}
+		if err := c.CopyToPod(context.Background(), src, dest, opts); err != nil {
+			t.Fatal(err)
+		}
+		check(t, dest,
+			func(string) os.FileMode { return 0600 },
+			func(string) os.FileMode { return 0711 })
+	})
+}
+
+func TestParseDFAvailable(t *testing.T) {
+	const header = "Filesystem     1024-blocks      Used Available Capacity Mounted on\n"
+	for _, tt := range []struct {
+		name   string
+		output string
+		want   int64
+		ok     bool
+	}{
+		{"plain", header + "/dev/sda1 1000 400 600 40% /data\n", 600 << 10, true},
+		{"mount point with spaces", header + "/dev/sda1 1000 400 600 40% /mnt/my data 7 8 9 10%\n", 600 << 10, true},
+		{"filesystem with spaces", header + "my server:/export 1000 400 600 40% /data\n", 600 << 10, true},
+		{"wrapped filesystem", header + "/dev/mapper/very-long-volume-name\n 1000 400 600 40% /data\n", 600 << 10, true},
+		{"no data line", header, 0, false},
+		{"garbage", header + "df: /data: No such file or directory\n", 0, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDFAvailable(tt.output)
+			if (err == nil) != tt.ok || got != tt.want {
+				t.Errorf("parseDFAvailable = %d, %v, want %d, ok %v", got, err, tt.want, tt.ok)
+			}
+		})
+	}
+}
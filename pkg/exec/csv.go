@@ -0,0 +1,95 @@
+package exec
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+)
+
+// CSVOptions configure ExecPodCSVWithOptions.
+type CSVOptions struct {
+	// Comma is the field delimiter. It defaults to ','.
+	Comma rune
+	// Header treats the first record as a header: it is passed to OnHeader,
+	// if set, instead of to onRecord.
+	Header   bool
+	OnHeader func(header []string) error
+}
+
+// ExecPodCSV runs command and parses its stdout as comma separated values as
+// it arrives, calling onRecord for each record, so large result sets don't
+// need to be held in memory. See ExecPodCSVWithOptions.
+func (c *Client) ExecPodCSV(ctx context.Context, command []string, onRecord func([]string) error) error {
+	return c.ExecPodCSVWithOptions(ctx, command, CSVOptions{}, onRecord)
+}
+
+// ExecPodCSVWithOptions is ExecPodCSV with a configurable delimiter and
+// header handling. It stops the command at the first parse error, which
+// carries the line number, or at the first error from a callback, and
+// returns that error.
+func (c *Client) ExecPodCSVWithOptions(ctx context.Context, command []string, opts CSVOptions, onRecord func([]string) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	pr, pw := io.Pipe()
+	stderr := &limitedBuffer{limit: c.MaxCaptureBytes}
+	done := make(chan error, 1)
+	go func() {
+		err := c.exec(ctx, execOptions{
+			command: command,
+			explain: true,
+			stdout:  pw,
+			stderr:  stderr,
+		})
+		pw.Close()
+		done <- err
+	}()
+
+	parseErr := parseCSV(pr, opts, onRecord)
+	if parseErr != nil {
+		cancel()
+		pr.CloseWithError(parseErr)
+	}
+	err := <-done
+	if parseErr != nil {
+		return parseErr
+	}
+	if err != nil {
+		return withStderr(err, c.decodeOutput(stderr.Bytes()))
+	}
+	return nil
+}
+
+// parseCSV reads records from r until EOF and hands them to the callbacks.
+func parseCSV(r io.Reader, opts CSVOptions, onRecord func([]string) error) error {
+	reader := csv.NewReader(r)
+	if opts.Comma != 0 {
+		reader.Comma = opts.Comma
+	}
+	reader.FieldsPerRecord = -1
+
+	header := opts.Header
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to parse CSV output: %w", err)
+		}
+
+		if header {
+			header = false
+			if opts.OnHeader != nil {
+				if err := opts.OnHeader(record); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := onRecord(record); err != nil {
+			return err
+		}
+	}
+}
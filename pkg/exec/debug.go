@@ -0,0 +1,254 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+)
+
+// debugKeepalive keeps an ephemeral debug container running so it can be
+// exec'ed into. It is also how ResolveProcesses recognizes the container's
+// own process.
+var debugKeepalive = []string{"sleep", "2147483647"}
+
+// debugStartTimeout bounds how long StartDebugContainer waits for the
+// container to run, e.g. while its image is pulled.
+const debugStartTimeout = 2 * time.Minute
+
+// ProcessInfo describes a process seen through a shared PID namespace.
+type ProcessInfo struct {
+	PID  int
+	PPID int
+	Cmd  string
+}
+
+// StartDebugContainer adds an ephemeral container running image to the
+// target pod and waits for it to run. It targets the client's container, so
+// the runtime puts it in that container's PID namespace. The container's name
+// is returned and recorded as DebugContainer for later calls.
+func (c *Client) StartDebugContainer(ctx context.Context, image string) (string, error) {
+	pod, err := c.getPod(ctx)
+	if err != nil {
+		return "", err
+	}
+	target, err := c.targetContainer(pod)
+	if err != nil {
+		return "", err
+	}
+
+	name, err := c.addEphemeralContainer(ctx, pod, image, debugKeepalive, target.Name)
+	if err != nil {
+		return "", err
+	}
+	c.DebugContainer = name
+	return name, nil
+}
+
+// addEphemeralContainer adds an ephemeral container running command in image
+// to pod and waits for it to run. target, if set, is the container whose PID
+// namespace it joins.
+func (c *Client) addEphemeralContainer(ctx context.Context, pod *corev1.Pod, image string, command []string, target string) (string, error) {
+	pods := c.CoreV1().Pods(pod.Namespace)
+	ecs, err := pods.GetEphemeralContainers(ctx, pod.Name, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", fmt.Errorf("ephemeral containers are not enabled on this cluster: %w", err)
+		}
+		return "", fmt.Errorf("failed to get ephemeral containers of pod %s/%s: %w", pod.Namespace, pod.Name, err)
+	}
+
+	name := "debugger-" + utilrand.String(5)
+	ecs.EphemeralContainers = append(ecs.EphemeralContainers, corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:                     name,
+			Image:                    image,
+			Command:                  command,
+			ImagePullPolicy:          corev1.PullIfNotPresent,
+			TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+		},
+		TargetContainerName: target,
+	})
+
+	log.Info("adding ephemeral debug container", zap.String("namespace", pod.Namespace), zap.String("pod", pod.Name), zap.String("container", name), zap.String("image", image))
+	if _, err := pods.UpdateEphemeralContainers(ctx, pod.Name, ecs, metav1.UpdateOptions{}); err != nil {
+		if apierrors.IsForbidden(err) {
+			return "", fmt.Errorf("no permissions to add ephemeral containers: %w", err)
+		}
+		return "", fmt.Errorf("failed to add ephemeral container: %w", err)
+	}
+
+	if err := c.waitForEphemeralContainer(ctx, pod.Name, name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// networkHelperPIDFile is where the container of ExecInPodNetwork records the
+// PID of its sleep.
+const networkHelperPIDFile = "/tmp/.k8sutils-helper.pid"
+
+// networkHelperKeepalive keeps the container of ExecInPodNetwork running
+// until its sleep is killed. The sleep is never PID 1: that is the shell in
+// the helper's own PID namespace, or the pod's infra process when the pod
+// shares its process namespace. The trap lets the shell exit on SIGTERM even
+// as PID 1.
+var networkHelperKeepalive = shellCommand("trap 'exit 0' TERM; sleep 2147483647 & echo $! >" + networkHelperPIDFile + "; wait; exit 0")
+
+// networkHelperStop kills the sleep recorded by networkHelperKeepalive, which
+// stops the helper. It refuses to signal PID 1.
+var networkHelperStop = shellCommand(`pid=$(cat ` + networkHelperPIDFile + `) || exit 1; if [ "$pid" -le 1 ]; then echo "refusing to kill PID $pid" >&2; exit 1; fi; kill "$pid"`)
+
+// ExecInPodNetwork runs command, typically a network tool such as ss, ip or
+// tcpdump, in a new ephemeral container of helperImage in the target pod.
+// Every container of a pod shares its network namespace, so the command sees
+// the pod's interfaces and sockets even when the pod's own images ship no
+// tools, as distroless ones don't. The helper targets no container, so it gets
+// its own PID namespace unless the pod sets shareProcessNamespace, in which
+// case it sees the pod's processes; use StartDebugContainer to see the
+// target's processes either way.
+//
+// Ephemeral containers can't be removed from a pod, so once the command is
+// done the helper is stopped instead; it stays listed as terminated. The
+// helper image needs sh. Clusters without ephemeral containers and missing
+// RBAC permissions are reported as such.
+func (c *Client) ExecInPodNetwork(ctx context.Context, command []string, helperImage string, stdout, stderr io.Writer) error {
+	if len(command) == 0 {
+		return fmt.Errorf("empty command")
+	}
+
+	pod, err := c.getPod(ctx)
+	if err != nil {
+		return err
+	}
+	name, err := c.addEphemeralContainer(ctx, pod, helperImage, networkHelperKeepalive, "")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		stopCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		var stopStderr bytes.Buffer
+		err := c.exec(stopCtx, execOptions{
+			pod:       pod.Name,
+			container: name,
+			command:   networkHelperStop,
+			stderr:    &stopStderr,
+		})
+		if err != nil {
+			log.Warn("failed to stop network helper container", zap.String("pod", pod.Name), zap.String("container", name), zap.Error(withStderr(err, stopStderr.String())))
+		}
+	}()
+
+	return c.exec(ctx, execOptions{
+		pod:       pod.Name,
+		container: name,
+		command:   command,
+		explain:   true,
+		stdout:    stdout,
+		stderr:    stderr,
+	})
+}
+
+// waitForEphemeralContainer polls the pod until the named ephemeral container
+// runs, terminates or debugStartTimeout elapses.
+func (c *Client) waitForEphemeralContainer(ctx context.Context, podName, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, debugStartTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		pod, err := c.CoreV1().Pods(c.Namespace).Get(ctx, podName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get pod %s/%s: %w", c.Namespace, podName, err)
+		}
+		for _, status := range pod.Status.EphemeralContainerStatuses {
+			if status.Name != name {
+				continue
+			}
+			switch {
+			case status.State.Running != nil:
+				return nil
+			case status.State.Terminated != nil:
+				return fmt.Errorf("ephemeral container %s terminated: %s", name, status.State.Terminated.Reason)
+			case status.State.Waiting != nil && strings.Contains(status.State.Waiting.Reason, "Err"):
+				return fmt.Errorf("ephemeral container %s is not starting: %s", name, status.State.Waiting.Reason)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for ephemeral container %s: %w", name, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// ResolveProcesses lists the target container's processes by running ps in
+// the ephemeral debug container (see StartDebugContainer). This only works
+// when the debug container shares the target's PID namespace, either because
+// it was started targeting the container or because the pod sets
+// shareProcessNamespace; otherwise only the debug container's own processes
+// would be visible. The ps probe and the debug container's keepalive process
+// are left out.
+func (c *Client) ResolveProcesses(ctx context.Context) ([]ProcessInfo, error) {
+	if c.DebugContainer == "" {
+		return nil, fmt.Errorf("no debug container, start one with StartDebugContainer")
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := c.exec(ctx, execOptions{
+		container: c.DebugContainer,
+		command:   shellCommand("echo $$; exec ps -o pid,ppid,args"),
+		stdout:    &stdout,
+		stderr:    &stderr,
+	})
+	if err != nil {
+		return nil, withStderr(err, stderr.String())
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("unexpected ps output %q", stdout.String())
+	}
+	self, err := strconv.Atoi(strings.TrimSpace(lines[0]))
+	if err != nil {
+		return nil, fmt.Errorf("unexpected ps output %q", stdout.String())
+	}
+
+	keepalive := strings.Join(debugKeepalive, " ")
+	var procs []ProcessInfo
+	// lines[1] is the ps header.
+	for _, line := range lines[2:] {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		ppid, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		cmd := strings.Join(fields[2:], " ")
+		if pid == self || cmd == keepalive {
+			continue
+		}
+		procs = append(procs, ProcessInfo{PID: pid, PPID: ppid, Cmd: cmd})
+	}
+	return procs, nil
+}
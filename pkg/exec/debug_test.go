@@ -0,0 +1,51 @@
+package exec
+
+import (
+	"os"
+	osexec "os/exec"
+	"testing"
+	"time"
+)
+
+func TestNetworkHelperStop(t *testing.T) {
+	os.Remove(networkHelperPIDFile)
+	defer os.Remove(networkHelperPIDFile)
+
+	helper := osexec.Command(networkHelperKeepalive[0], networkHelperKeepalive[1:]...)
+	if err := helper.Start(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- helper.Wait() }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if b, err := os.ReadFile(networkHelperPIDFile); err == nil && len(b) > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("helper did not record its PID")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if out, err := osexec.Command(networkHelperStop[0], networkHelperStop[1:]...).CombinedOutput(); err != nil {
+		t.Fatalf("stop = %v: %s", err, out)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("helper exited with %v, want success", err)
+		}
+	case <-time.After(5 * time.Second):
+		helper.Process.Kill()
+		t.Fatal("helper still running after stop")
+	}
+
+	if err := os.WriteFile(networkHelperPIDFile, []byte("1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := osexec.Command(networkHelperStop[0], networkHelperStop[1:]...).Run(); err == nil {
+		t.Error("stop signaled PID 1")
+	}
+}
@@ -0,0 +1,47 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// defaultDiagTimeout bounds a DiagCommand that sets no Timeout.
+const defaultDiagTimeout = 30 * time.Second
+
+// DiagCommand is one named command of a diagnostic bundle, e.g. "mem" for
+// cat /proc/meminfo.
+type DiagCommand struct {
+	Name    string
+	Command []string
+	// Timeout bounds this command; it defaults to 30 seconds.
+	Timeout time.Duration
+}
+
+// CaptureDiagnostics runs commands one after another in the target container
+// and returns each one's output, stdout followed by stderr, by name. A failing
+// command doesn't stop the rest; its output is still kept and its error is
+// part of the joined error returned at the end. When ctx ends, the outputs
+// gathered so far are returned along with ctx's error.
+func (c *Client) CaptureDiagnostics(ctx context.Context, commands []DiagCommand) (map[string][]byte, error) {
+	bundle := make(map[string][]byte, len(commands))
+	var errs []error
+	for _, cmd := range commands {
+		if ctx.Err() != nil {
+			errs = append(errs, fmt.Errorf("diagnostics interrupted before %s: %w", cmd.Name, ctx.Err()))
+			break
+		}
+
+		timeout := cmd.Timeout
+		if timeout <= 0 {
+			timeout = defaultDiagTimeout
+		}
+		stdout, stderr, err := c.captureExplained(ctx, cmd.Command, timeout)
+		bundle[cmd.Name] = []byte(stdout + stderr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", cmd.Name, err))
+		}
+	}
+	return bundle, errors.Join(errs...)
+}
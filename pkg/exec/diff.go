@@ -0,0 +1,207 @@
+package exec
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// diffOp is one line of an edit script: ' ' keeps, '-' deletes and '+'
+// inserts a line.
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// diffLines returns a shortest edit script turning a into b, computed with
+// the linear space variant of Myers' algorithm: instead of recording every
+// step of the search, it finds the middle snake of an optimal path and
+// recurses on the halves before and after it, needing O(N+M) memory.
+func diffLines(a, b []string) []diffOp {
+	ops := make([]diffOp, 0, len(a)+len(b))
+	return diffRange(ops, a, b)
+}
+
+// diffRange appends the edit script turning a into b to ops.
+func diffRange(ops []diffOp, a, b []string) []diffOp {
+	// Common lines at either end are kept as they are. What is left either
+	// has a side empty or is split at a middle snake into two problems with
+	// fewer edits each.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		ops = append(ops, diffOp{' ', a[prefix]})
+		prefix++
+	}
+	a, b = a[prefix:], b[prefix:]
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	common := a[len(a)-suffix:]
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	switch {
+	case len(a) == 0:
+		for _, line := range b {
+			ops = append(ops, diffOp{'+', line})
+		}
+	case len(b) == 0:
+		for _, line := range a {
+			ops = append(ops, diffOp{'-', line})
+		}
+	default:
+		x, y, u, v := middleSnake(a, b)
+		ops = diffRange(ops, a[:x], b[:y])
+		for _, line := range a[x:u] {
+			ops = append(ops, diffOp{' ', line})
+		}
+		ops = diffRange(ops, a[u:], b[v:])
+	}
+
+	for _, line := range common {
+		ops = append(ops, diffOp{' ', line})
+	}
+	return ops
+}
+
+// middleSnake returns the start (x, y) and end (u, v) of the snake in the
+// middle of a shortest path from (0, 0) to (len(a), len(b)), found by
+// searching forward from the start and backward from the end at the same
+// time until the two searches overlap. vf holds the furthest x reached on
+// each forward diagonal k = x - y, and vb the furthest distance from the end
+// reached on each backward diagonal.
+func middleSnake(a, b []string) (x, y, u, v int) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	max := (n + m + 1) / 2
+	off := max + 1
+	vf := make([]int, 2*max+3)
+	vb := make([]int, 2*max+3)
+
+	for d := 0; d <= max; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && vf[off+k-1] < vf[off+k+1]) {
+				x = vf[off+k+1]
+			} else {
+				x = vf[off+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			vf[off+k] = x
+			if rk := delta - k; odd && rk >= -(d-1) && rk <= d-1 && x+vb[off+rk] >= n {
+				return startX, startY, x, y
+			}
+		}
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && vb[off+k-1] < vb[off+k+1]) {
+				x = vb[off+k+1]
+			} else {
+				x = vb[off+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x++
+				y++
+			}
+			vb[off+k] = x
+			if fk := delta - k; !odd && fk >= -d && fk <= d && x+vf[off+fk] >= n {
+				return n - x, m - y, n - startX, m - startY
+			}
+		}
+	}
+	// Unreachable: the searches always meet within max steps.
+	return 0, 0, 0, 0
+}
+
+// splitLines splits s into lines without their newlines.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// isBinary reports whether data looks like binary rather than text: it
+// contains a NUL byte or is not valid UTF-8.
+func isBinary(data []byte) bool {
+	return bytes.IndexByte(data, 0) >= 0 || !utf8.Valid(data)
+}
+
+// unifiedDiff returns a unified diff turning from into to, or "" if they are
+// equal.
+func unifiedDiff(fromName, toName, from, to string) string {
+	ops := diffLines(splitLines(from), splitLines(to))
+
+	var changes []int
+	for i, op := range ops {
+		if op.kind != ' ' {
+			changes = append(changes, i)
+		}
+	}
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+
+	for i := 0; i < len(changes); {
+		start := changes[i] - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := changes[i] + diffContext + 1
+		for i++; i < len(changes) && changes[i]-diffContext <= end; i++ {
+			end = changes[i] + diffContext + 1
+		}
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		var fromLine, toLine int
+		for _, op := range ops[:start] {
+			if op.kind != '+' {
+				fromLine++
+			}
+			if op.kind != '-' {
+				toLine++
+			}
+		}
+		var fromCount, toCount int
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				fromCount++
+			}
+			if op.kind != '-' {
+				toCount++
+			}
+		}
+		if fromCount > 0 {
+			fromLine++
+		}
+		if toCount > 0 {
+			toLine++
+		}
+
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", fromLine, fromCount, toLine, toCount)
+		for _, op := range ops[start:end] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.line)
+			out.WriteByte('\n')
+		}
+	}
+	return out.String()
+}
@@ -0,0 +1,104 @@
+package exec
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// lcsLength returns the length of the longest common subsequence of a and b.
+func lcsLength(a, b []string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			switch {
+			case a[i] == b[j]:
+				cur[j+1] = prev[j] + 1
+			case prev[j+1] > cur[j]:
+				cur[j+1] = prev[j+1]
+			default:
+				cur[j+1] = cur[j]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func TestDiffLinesShortestScript(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, rng.Intn(30))
+		for i := range lines {
+			lines[i] = string(rune('a' + rng.Intn(4)))
+		}
+		return lines
+	}
+
+	for i := 0; i < 2000; i++ {
+		a, b := randomLines(), randomLines()
+		ops := diffLines(a, b)
+
+		var from, to []string
+		edits := 0
+		for _, op := range ops {
+			if op.kind != '+' {
+				from = append(from, op.line)
+			}
+			if op.kind != '-' {
+				to = append(to, op.line)
+			}
+			if op.kind != ' ' {
+				edits++
+			}
+		}
+		if strings.Join(from, ",") != strings.Join(a, ",") || strings.Join(to, ",") != strings.Join(b, ",") {
+			t.Fatalf("script for %q -> %q doesn't reproduce the inputs: %v", a, b, ops)
+		}
+		if want := len(a) + len(b) - 2*lcsLength(a, b); edits != want {
+			t.Fatalf("script for %q -> %q has %d edits, want %d", a, b, edits, want)
+		}
+	}
+}
+
+func TestDiffLinesLargeDisjointInputs(t *testing.T) {
+	a := make([]string, 5000)
+	b := make([]string, 5000)
+	for i := range a {
+		a[i] = fmt.Sprintf("a%d", i)
+		b[i] = fmt.Sprintf("b%d", i)
+	}
+	if ops := diffLines(a, b); len(ops) != len(a)+len(b) {
+		t.Fatalf("got %d ops, want %d", len(ops), len(a)+len(b))
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	from := "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n"
+	to := "1\n2\n3\nfour\n5\n6\n7\n8\n9\n10\n11\n12\n13\n"
+	want := `--- a
++++ b
+@@ -1,7 +1,7 @@
+ 1
+ 2
+ 3
+-4
++four
+ 5
+ 6
+ 7
+@@ -10,3 +10,4 @@
+ 10
+ 11
+ 12
++13
+`
+	if got := unifiedDiff("a", "b", from, to); got != want {
+		t.Errorf("unifiedDiff =\n%s\nwant\n%s", got, want)
+	}
+	if got := unifiedDiff("a", "b", from, from); got != "" {
+		t.Errorf("unifiedDiff of equal inputs = %q, want empty", got)
+	}
+}
@@ -0,0 +1,136 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// envCommand wraps command so it runs with env added to the container's
+// environment, using env(1). Variables are passed in sorted order.
+func envCommand(env map[string]string, command []string) []string {
+	if len(env) == 0 {
+		return command
+	}
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	wrapped := make([]string, 0, len(env)+len(command)+1)
+	wrapped = append(wrapped, "env")
+	for _, k := range keys {
+		wrapped = append(wrapped, k+"="+env[k])
+	}
+	return append(wrapped, command...)
+}
+
+// envVarName matches the keys configEnv accepts as variable names.
+var envVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// stdinEnvScript exports the variable assignments read from stdin and then
+// runs its arguments, so the values never appear in a command line.
+const stdinEnvScript = `eval "$(cat)" && exec "$@"`
+
+// stdinEnvCommand wraps command so it runs with env added to its environment
+// by stdinEnvScript. The returned stdin carries the assignments, one export
+// per variable in sorted order; command itself gets no stdin.
+func stdinEnvCommand(env map[string]string, command []string) ([]string, io.Reader) {
+	if len(env) == 0 {
+		return command, nil
+	}
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var script strings.Builder
+	for _, k := range keys {
+		script.WriteString("export " + k + "=" + shellQuote(env[k]) + "\n")
+	}
+	return append([]string{"sh", "-c", stdinEnvScript, "sh"}, command...), strings.NewReader(script.String())
+}
+
+// localeCommand wraps command to run with LC_ALL and LANG set to the
+// ForceLocale option, if it is set.
+func (c *Client) localeCommand(command []string) []string {
+	if c.ForceLocale == "" {
+		return command
+	}
+	return envCommand(map[string]string{"LANG": c.ForceLocale, "LC_ALL": c.ForceLocale}, command)
+}
+
+// configEnv collects the keys of the named ConfigMap and Secret as
+// environment variables. Either name may be empty. Secret keys win over
+// ConfigMap keys, like later envFrom sources do in a pod spec; keys that
+// can't be variable names, i.e. don't match envVarName, are skipped.
+func (c *Client) configEnv(ctx context.Context, configMapRef, secretRef string) (map[string]string, error) {
+	env := make(map[string]string)
+
+	if configMapRef != "" {
+		cm, err := c.CoreV1().ConfigMaps(c.Namespace).Get(ctx, configMapRef, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("configmap %s/%s not found", c.Namespace, configMapRef)
+			}
+			return nil, fmt.Errorf("failed to get configmap %s/%s: %w", c.Namespace, configMapRef, err)
+		}
+		for k, v := range cm.Data {
+			if envVarName.MatchString(k) {
+				env[k] = v
+			}
+		}
+	}
+
+	if secretRef != "" {
+		secret, err := c.CoreV1().Secrets(c.Namespace).Get(ctx, secretRef, metav1.GetOptions{})
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, fmt.Errorf("secret %s/%s not found", c.Namespace, secretRef)
+			}
+			return nil, fmt.Errorf("failed to get secret %s/%s: %w", c.Namespace, secretRef, err)
+		}
+		for k, v := range secret.Data {
+			if envVarName.MatchString(k) {
+				env[k] = string(v)
+			}
+		}
+	}
+
+	return env, nil
+}
+
+// ExecPodWithConfigEnv runs command with the keys of the referenced ConfigMap
+// and Secret in its environment, the way a container with envFrom would see
+// them. Either reference may be empty. The values are sent on the exec's stdin
+// and exported by sh, so they never appear in the command line, in logs or to
+// other processes in the container; the container needs sh and cat, and the
+// command gets no stdin. They are applied after ForceLocale, so LANG or LC_ALL
+// keys in the ConfigMap or Secret override the forced locale.
+func (c *Client) ExecPodWithConfigEnv(ctx context.Context, command []string, configMapRef, secretRef string, timeout time.Duration) error {
+	env, err := c.configEnv(ctx, configMapRef, secretRef)
+	if err != nil {
+		return err
+	}
+
+	wrapped, stdin := stdinEnvCommand(env, command)
+	_, stderr, err := c.captureWith(ctx, execOptions{
+		command: wrapped,
+		stdin:   stdin,
+		timeout: timeout,
+		explain: true,
+	})
+	if err != nil {
+		return withStderr(err, stderr)
+	}
+	return nil
+}
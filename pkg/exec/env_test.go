@@ -0,0 +1,39 @@
+package exec
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestExecPodWithConfigEnv(t *testing.T) {
+	const secret = "p@ss w'rd $HOME\nline2"
+	c, execs := newTestClient(t, ClientOpt{})
+	useFakeAPI(c,
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "cm", Namespace: "default"},
+			Data:       map[string]string{"MODE": "config", "TOKEN": "from-config", "-x": "1", "1a": "1", "a.b": "1"},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "secret", Namespace: "default"},
+			Data:       map[string][]byte{"TOKEN": []byte(secret), "EMPTY": nil},
+		},
+	)
+
+	check := `test "$MODE" = config && test "$TOKEN" = "$1" && test "${EMPTY-unset}" = "" && ! env | grep -q -e '^-x=' -e '^1a=' -e '^a.b='`
+	err := c.ExecPodWithConfigEnv(context.Background(), []string{"sh", "-c", check, "sh", secret}, "cm", "secret", time.Minute)
+	if err != nil {
+		t.Fatalf("ExecPodWithConfigEnv = %v", err)
+	}
+
+	// The check itself gets the value as an argument; only the variables
+	// must stay out of the exec's command line.
+	command := execs.last(t).command
+	if got := strings.Join(command[:len(command)-1], " "); strings.Contains(got, "line2") {
+		t.Errorf("exec command %q contains the secret value", got)
+	}
+}
@@ -2,15 +2,19 @@ package exec
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/pingcap/log"
 	"go.uber.org/zap"
 	"io"
+	"k8s.io/apimachinery/pkg/util/httpstream"
 	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
 	spdy2 "k8s.io/client-go/transport/spdy"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
+	"syscall"
 	"time"
 
 	authzv1 "k8s.io/api/authorization/v1"
@@ -20,6 +24,7 @@ import (
 	"k8s.io/client-go/rest"
 	restclient "k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
 )
 
 var deniedCreateExecErr = fmt.Errorf("no permissions to create exec subresource")
@@ -27,48 +32,352 @@ var deniedCreateExecErr = fmt.Errorf("no permissions to create exec subresource"
 // ExecPod issues an exec request to execute the given command to a particular
 // pod.
 func (c *Client) ExecPod(command []string, stdin io.Reader, stdout, stderr io.Writer, tty bool, timeout time.Duration) error {
-	log.Info("sending exec request, command=%s, namespace=%S, pod=%s, container=%s", zap.String("command", strings.Join(command, " ")), zap.String("namespace", c.Namespace), zap.String("pod", c.PodName), zap.String("container", c.ContainerName), zap.String("timeout", timeout.String()))
+	return c.exec(context.Background(), execOptions{
+		command: command,
+		explain: true,
+		stdin:   stdin,
+		stdout:  stdout,
+		stderr:  stderr,
+		tty:     tty,
+		timeout: timeout,
+	})
+}
+
+// KubectlEquivalent returns a copy-pasteable kubectl exec command line that
+// runs command the way ExecPod would, e.g. to let users reproduce an issue
+// by hand. tty adds -it. When the pod is found through Target and has not
+// been resolved yet, the configured PodName is used.
+func (c *Client) KubectlEquivalent(command []string, tty bool) string {
+	c.mu.Lock()
+	pod := c.resolvedPod
+	c.mu.Unlock()
+	if pod == "" {
+		pod = c.PodName
+	}
+
+	args := []string{"kubectl"}
+	if c.CurrentContext != "" {
+		args = append(args, "--context", c.CurrentContext)
+	}
+	args = append(args, "exec")
+	if tty {
+		args = append(args, "-it")
+	}
+	args = append(args, "-n", c.Namespace, pod)
+	if c.ContainerName != "" {
+		args = append(args, "-c", c.ContainerName)
+	}
+	args = append(args, "--")
+	args = append(args, c.Privilege.wrap(c.localeCommand(command))...)
+	return shellJoin(args)
+}
+
+// execOptions describes a single exec stream.
+type execOptions struct {
+	// pod overrides the client's target pod when set.
+	pod string
+	// container overrides the client's ContainerName when set.
+	container string
+	command   []string
+	stdin     io.Reader
+	stdout    io.Writer
+	stderr    io.Writer
+	tty       bool
+	// sizeQueue propagates local terminal resizes when tty is set.
+	sizeQueue remotecommand.TerminalSizeQueue
+	timeout   time.Duration
+	// escalated is set once command has been wrapped for the Privilege
+	// option.
+	escalated bool
+	// explain is set by the caller-facing entry points, so ExplainOnFailure
+	// leaves internal probes, whose failures are often expected, alone.
+	explain bool
+	// unlimited skips MaxConcurrentStreams and the StreamLimiter, for short
+	// execs that an exec already holding a slot depends on.
+	unlimited bool
+}
+
+// exec runs a single exec stream against the target container. The stream is
+// torn down when ctx is done or the timeout elapses.
+func (c *Client) exec(ctx context.Context, opts execOptions) error {
+	if !opts.escalated {
+		opts.command = c.localeCommand(opts.command)
+	}
+	if c.Privilege != PrivilegeNone && !opts.escalated && opts.pod == "" {
+		return c.execEscalated(ctx, opts)
+	}
+
+	podName := opts.pod
+	if podName == "" {
+		var err error
+		if podName, err = c.podName(ctx); err != nil {
+			return err
+		}
+	}
+	container := c.ContainerName
+	if opts.container != "" {
+		container = opts.container
+	}
+
+	log.Info("sending exec request", zap.String("command", strings.Join(opts.command, " ")), zap.String("namespace", c.Namespace), zap.String("pod", podName), zap.String("container", container), zap.String("timeout", opts.timeout.String()))
+
+	if opts.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.timeout)
+		defer cancel()
+	}
+
+	if err := c.preflight(ctx); err != nil {
+		return err
+	}
+
+	if opts.pod == "" {
+		lastGasp, err := c.terminatingTimeout(ctx)
+		if err != nil {
+			return err
+		}
+		if lastGasp > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, lastGasp)
+			defer cancel()
+		}
+	}
+
+	if !opts.unlimited {
+		release, err := c.acquireStream(ctx)
+		if err != nil {
+			return err
+		}
+		defer release()
+	}
+
+	var (
+		breaker *circuitBreaker
+		err     error
+	)
+	if opts.pod == "" {
+		if breaker, err = c.circuitBreaker(ctx); err != nil {
+			return err
+		}
+	}
+
+	if stdin := wrapFileStdin(opts.stdin); stdin != nil {
+		opts.stdin = stdin
+		defer stdin.release()
+	}
+
+	var stdout *abortWriter
+	if c.StopOnStdoutClose && opts.stdout != nil {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		stdout = &abortWriter{fn: writeAll(opts.stdout), cancel: cancel}
+		opts.stdout = stdout
+	}
+
+	var stderrTail *RingBuffer
+	if c.ExplainOnFailure && opts.explain && opts.pod == "" && !opts.tty {
+		stderrTail = RingCapture(explainStderrBytes)
+		if opts.stderr != nil {
+			opts.stderr = io.MultiWriter(opts.stderr, stderrTail)
+		} else {
+			opts.stderr = stderrTail
+		}
+	}
+
+	err = c.streamRetrying(ctx, podName, container, opts)
+	breaker.record(err, errors.Is(ctx.Err(), context.Canceled))
+	if stdout != nil {
+		if writeErr := stdout.Err(); writeErr != nil {
+			if errors.Is(writeErr, io.ErrClosedPipe) || errors.Is(writeErr, syscall.EPIPE) || errors.Is(writeErr, os.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("failed to write stdout: %w", writeErr)
+		}
+	}
+	if err != nil && c.ExplainOnFailure && opts.explain && opts.pod == "" {
+		var stderr []byte
+		if stderrTail != nil {
+			stderr = stderrTail.Bytes()
+		}
+		return c.explainFailure(err, container, stderr)
+	}
+	return err
+}
+
+// streamRetrying streams opts, retrying failed attempts as the Retry policy
+// allows.
+func (c *Client) streamRetrying(ctx context.Context, podName, container string, opts execOptions) error {
+	// Retries on stderr need stderr to match and stdin to replay.
+	stderrRetries := c.Retry.RetryStderrPattern != nil && !opts.tty
+	var stdin io.Seeker
+	var stdinStart int64
+	if stderrRetries && opts.stdin != nil {
+		seeker, ok := opts.stdin.(io.Seeker)
+		if !ok {
+			stderrRetries = false
+		} else if pos, err := seeker.Seek(0, io.SeekCurrent); err != nil {
+			stderrRetries = false
+		} else {
+			stdin, stdinStart = seeker, pos
+		}
+	}
+
+	var waited time.Duration
+	for attempt := 1; ; attempt++ {
+		attemptOpts := opts
+		var stderrTail *RingBuffer
+		if stderrRetries {
+			stderrTail = RingCapture(stderrRetryTail)
+			if opts.stderr != nil {
+				attemptOpts.stderr = io.MultiWriter(opts.stderr, stderrTail)
+			} else {
+				attemptOpts.stderr = stderrTail
+			}
+		}
+
+		err := c.stream(ctx, podName, container, attemptOpts)
+		if err == nil {
+			return nil
+		}
+
+		delay, ok := c.Retry.retryDelay(err, attempt, waited)
+		if !ok && stderrTail != nil {
+			if delay, ok = c.Retry.stderrRetryDelay(err, stderrTail.Bytes(), attempt, waited); ok && stdin != nil {
+				if _, seekErr := stdin.Seek(stdinStart, io.SeekStart); seekErr != nil {
+					ok = false
+				}
+			}
+		}
+		if !ok {
+			return err
+		}
+		log.Info("retrying exec request", zap.String("pod", podName), zap.Int("attempt", attempt), zap.String("delay", delay.String()), zap.Error(err))
+		select {
+		case <-time.After(delay):
+			waited += delay
+		case <-ctx.Done():
+			return err
+		}
+
+		if opts.pod == "" && c.Retry.RediscoverPod && c.Target.selectorBased() {
+			c.forgetPod(podName)
+			if podName, err = c.podName(ctx); err != nil {
+				return err
+			}
+		}
+	}
+}
 
+// stream makes one exec request and streams it to completion.
+func (c *Client) stream(ctx context.Context, podName, container string, opts execOptions) error {
 	execRequest := c.CoreV1().RESTClient().Post().
 		Resource("pods").
 		Namespace(c.Namespace).
-		Name(c.PodName).
+		Name(podName).
 		SubResource("exec").
-		Timeout(timeout)
+		Timeout(RemainingTimeout(ctx, opts.timeout))
 
 	execRequest = execRequest.VersionedParams(&corev1.PodExecOptions{
-		Container: c.ContainerName,
-		Command:   command,
-		Stdin:     stdin != nil,
-		Stdout:    stdout != nil,
-		Stderr:    stderr != nil,
-		TTY:       tty,
+		Container: container,
+		Command:   opts.command,
+		Stdin:     opts.stdin != nil,
+		Stdout:    opts.stdout != nil,
+		Stderr:    opts.stderr != nil,
+		TTY:       opts.tty,
 	}, scheme.ParameterCodec)
 
-	exec, err := newExecutor(c.K8sConfig, "POST", execRequest.URL())
+	writes := &stdinWrites{}
+	exec, err := newExecutor(ctx, c.K8sConfig, c.TransportOptions, "POST", execRequest.URL(), writes)
 	if err != nil {
 		return fmt.Errorf("failed to set up executor: %w", err)
 	}
 
 	if err := exec.Stream(remotecommand.StreamOptions{
-		Stdin:  stdin,
-		Stdout: stdout,
-		Stderr: stderr,
-		Tty:    tty,
+		Stdin:             opts.stdin,
+		Stdout:            opts.stdout,
+		Stderr:            opts.stderr,
+		Tty:               opts.tty,
+		TerminalSizeQueue: opts.sizeQueue,
 	}); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("failed to exec command: %w", ctx.Err())
+		}
 		return fmt.Errorf("failed to exec command: %w", err)
 	}
 
+	if opts.stdin != nil {
+		return c.checkStdinWrites(writes.wait(stdinWriteGrace), opts)
+	}
 	return nil
 }
 
+// checkStdinWrites turns the error writing stdin of an exec whose command
+// exited cleanly into the exec's error. With IgnoreStdinBrokenPipe a broken
+// pipe, from a command that exited before reading all of its stdin, is
+// ignored instead.
+func (c *Client) checkStdinWrites(err error, opts execOptions) error {
+	if err == nil {
+		return nil
+	}
+	if c.IgnoreStdinBrokenPipe && brokenPipe(err) {
+		log.Info("ignoring broken pipe on stdin of exited command", zap.Strings("command", opts.command), zap.Error(err))
+		return nil
+	}
+	return fmt.Errorf("failed to write stdin: %w", err)
+}
+
+// acquireStream waits for a free stream slot when MaxConcurrentStreams is
+// set, then takes one from the shared StreamLimiter if there is one. The
+// returned func gives the slots back.
+func (c *Client) acquireStream(ctx context.Context) (func(), error) {
+	releaseLocal := func() {}
+	if c.streams != nil {
+		select {
+		case c.streams <- struct{}{}:
+			releaseLocal = func() { <-c.streams }
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting for a free exec stream: %w", ctx.Err())
+		}
+	}
+
+	releaseShared, err := c.StreamLimiter.acquire(ctx)
+	if err != nil {
+		releaseLocal()
+		return nil, err
+	}
+	return func() {
+		releaseShared()
+		releaseLocal()
+	}, nil
+}
+
+// exitCode extracts the remote command's exit status from an exec error. ok
+// is false when err does not carry an exit status.
+func exitCode(err error) (code int, ok bool) {
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) && exitErr.Exited() {
+		return exitErr.ExitStatus(), true
+	}
+	return 0, false
+}
+
 // CanExec determines if the current user can create a exec subresource in the
 // given pod.
 func (c *Client) CanExec() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	return c.canExecIn(ctx, c.Namespace)
+}
+
+// canExecIn checks whether the current user can create exec subresources in
+// namespace.
+func (c *Client) canExecIn(ctx context.Context, namespace string) error {
 	selfAccessReview := &authzv1.SelfSubjectAccessReview{
 		Spec: authzv1.SelfSubjectAccessReviewSpec{
 			ResourceAttributes: &authzv1.ResourceAttributes{
-				Namespace:   c.Namespace,
+				Namespace:   namespace,
 				Verb:        "create",
 				Group:       "",
 				Resource:    "pods",
@@ -78,10 +387,7 @@ func (c *Client) CanExec() error {
 		},
 	}
 
-	log.Info("checking for exec permissions.", zap.String("namespace", c.Namespace))
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	log.Info("checking for exec permissions.", zap.String("namespace", namespace))
 
 	response, err := c.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, selfAccessReview, metav1.CreateOptions{})
 	if err != nil {
@@ -95,12 +401,44 @@ func (c *Client) CanExec() error {
 		return deniedCreateExecErr
 	}
 
-	log.Info("confirmed exec permissions.", zap.String("namespace", c.Namespace))
+	log.Info("confirmed exec permissions.", zap.String("namespace", namespace))
 	return nil
 }
 
-var newExecutor = func(config *rest.Config, method string, url *url.URL) (remotecommand.Executor, error) {
-	return NewSPDYExecutor(config, method, url)
+var newExecutor = func(ctx context.Context, config *rest.Config, transport TransportOptions, method string, url *url.URL, stdin *stdinWrites) (remotecommand.Executor, error) {
+	wrapper, upgradeRoundTripper, err := RoundTripperForOptions(config, transport)
+	if err != nil {
+		return nil, err
+	}
+	return remotecommand.NewSPDYExecutorForTransports(wrapper, &contextUpgrader{Upgrader: upgradeRoundTripper, ctx: ctx, stdin: stdin}, method, url)
+}
+
+// contextUpgrader closes the upgraded connection once ctx is done, which
+// unblocks an in-flight Stream call, and records the stdin write errors in
+// stdin.
+type contextUpgrader struct {
+	spdy2.Upgrader
+	ctx   context.Context
+	stdin *stdinWrites
+}
+
+func (u *contextUpgrader) NewConnection(resp *http.Response) (httpstream.Connection, error) {
+	conn, err := u.Upgrader.NewConnection(resp)
+	if err != nil {
+		return nil, &upgradeError{
+			statusCode: resp.StatusCode,
+			retryAfter: resp.Header.Get("Retry-After"),
+			err:        err,
+		}
+	}
+	go func() {
+		select {
+		case <-u.ctx.Done():
+			conn.Close()
+		case <-conn.CloseChan():
+		}
+	}()
+	return &stdinConnection{Connection: conn, writes: u.stdin}, nil
 }
 
 func NewSPDYExecutor(config *restclient.Config, method string, url *url.URL) (remotecommand.Executor, error) {
@@ -111,7 +449,33 @@ func NewSPDYExecutor(config *restclient.Config, method string, url *url.URL) (re
 	return remotecommand.NewSPDYExecutorForTransports(wrapper, upgradeRoundTripper, method, url)
 }
 
+// TransportOptions tune the SPDY transport exec streams are carried over.
+type TransportOptions struct {
+	// DialTimeout bounds establishing each TCP connection, to the API server
+	// or to a proxy.
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout bounds the TLS handshake with the API server, also
+	// when it runs through a proxy, independently of DialTimeout.
+	TLSHandshakeTimeout time.Duration
+	// TCPKeepAlive is the TCP keepalive period of the connection, for
+	// middleboxes that drop idle connections despite SPDY pings. Zero uses
+	// Go's default of 15s; a negative value disables TCP keepalives.
+	TCPKeepAlive time.Duration
+
+	// SPDYConfig, when set, is the base configuration of the SPDY round
+	// tripper instead of the package defaults. Its TLS and Proxier are
+	// filled in from the rest config only if nil; every other field,
+	// including FollowRedirects and PingPeriod, is used as given.
+	SPDYConfig *spdy.RoundTripperConfig
+}
+
 func RoundTripperFor(config *restclient.Config) (http.RoundTripper, spdy2.Upgrader, error) {
+	return RoundTripperForOptions(config, TransportOptions{})
+}
+
+// RoundTripperForOptions is like RoundTripperFor but applies opts to the
+// transport.
+func RoundTripperForOptions(config *restclient.Config, opts TransportOptions) (http.RoundTripper, spdy2.Upgrader, error) {
 	tlsConfig, err := restclient.TLSConfigFor(config)
 	if err != nil {
 		return nil, nil, err
@@ -120,13 +484,31 @@ func RoundTripperFor(config *restclient.Config) (http.RoundTripper, spdy2.Upgrad
 	if config.Proxy != nil {
 		proxy = config.Proxy
 	}
-	upgradeRoundTripper := spdy.NewRoundTripperWithConfig(spdy.RoundTripperConfig{
+	spdyConfig := spdy.RoundTripperConfig{
 		TLS:                      tlsConfig,
 		FollowRedirects:          true,
 		RequireSameHostRedirects: false,
 		Proxier:                  proxy,
 		PingPeriod:               0,
-	})
+	}
+	if opts.SPDYConfig != nil {
+		spdyConfig = *opts.SPDYConfig
+		if spdyConfig.TLS == nil {
+			spdyConfig.TLS = tlsConfig
+		}
+		if spdyConfig.Proxier == nil {
+			spdyConfig.Proxier = proxy
+		}
+	}
+	var upgradeRoundTripper interface {
+		http.RoundTripper
+		spdy2.Upgrader
+	}
+	if opts.DialTimeout > 0 || opts.TLSHandshakeTimeout > 0 || opts.TCPKeepAlive != 0 {
+		upgradeRoundTripper = newSetupRoundTripper(spdyConfig, opts)
+	} else {
+		upgradeRoundTripper = spdy.NewRoundTripperWithConfig(spdyConfig)
+	}
 	wrapper, err := restclient.HTTPWrappersForConfig(config, upgradeRoundTripper)
 	if err != nil {
 		return nil, nil, err
@@ -0,0 +1,235 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	osexec "os/exec"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
+)
+
+// execRecord is one exec request seen by a localExecutor.
+type execRecord struct {
+	command []string
+	tty     bool
+	stderr  bool
+	// size is the first size read from the terminal size queue.
+	size *remotecommand.TerminalSize
+}
+
+// fakeExecs collects the exec requests made through a test client.
+type fakeExecs struct {
+	mu      sync.Mutex
+	records []execRecord
+}
+
+func (f *fakeExecs) add(r execRecord) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.records = append(f.records, r)
+}
+
+func (f *fakeExecs) last(t *testing.T) execRecord {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.records) == 0 {
+		t.Fatal("no exec request was made")
+	}
+	return f.records[len(f.records)-1]
+}
+
+// localExecutor runs the command of an exec request on the local machine,
+// standing in for the container. Like remotecommand, it copies stdin in a
+// goroutine it doesn't wait for and doesn't return the copy's errors; writes
+// go through stdin, as they do through the stdin stream of a real exec.
+type localExecutor struct {
+	ctx     context.Context
+	command []string
+	stdin   *stdinWrites
+	execs   *fakeExecs
+}
+
+func (e *localExecutor) Stream(opts remotecommand.StreamOptions) error {
+	record := execRecord{command: e.command, tty: opts.Tty, stderr: opts.Stderr != nil}
+	if opts.TerminalSizeQueue != nil {
+		record.size = opts.TerminalSizeQueue.Next()
+	}
+	e.execs.add(record)
+
+	cmd := osexec.CommandContext(e.ctx, e.command[0], e.command[1:]...)
+	cmd.Stdout = opts.Stdout
+	cmd.Stderr = opts.Stderr
+	if opts.Tty {
+		cmd.Stderr = opts.Stdout
+	}
+
+	var stdinReader, stdinWriter *os.File
+	if opts.Stdin != nil {
+		var err error
+		if stdinReader, stdinWriter, err = os.Pipe(); err != nil {
+			return err
+		}
+		cmd.Stdin = stdinReader
+	}
+	err := cmd.Start()
+	if stdinReader != nil {
+		// Close our copy of the read end, so writes fail with EPIPE once the
+		// command is gone, like they do on a real stream.
+		stdinReader.Close()
+	}
+	if err != nil {
+		if stdinWriter != nil {
+			stdinWriter.Close()
+		}
+		return err
+	}
+
+	if stdinWriter != nil {
+		go func() {
+			io.Copy(e.stdin.wrap(stdinWriter), opts.Stdin)
+			stdinWriter.Close()
+		}()
+	}
+
+	err = cmd.Wait()
+	var exitErr *osexec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+		return utilexec.CodeExitError{
+			Err:  fmt.Errorf("command terminated with non-zero exit code: %w", err),
+			Code: exitErr.ExitCode(),
+		}
+	}
+	return err
+}
+
+// newTestClient returns a client whose execs run locally through a
+// localExecutor, and the record of those execs.
+func newTestClient(t *testing.T, opt ClientOpt) (*Client, *fakeExecs) {
+	t.Helper()
+	opt.K8sConfig = &rest.Config{Host: "http://127.0.0.1:1"}
+	if opt.PodName == "" {
+		opt.PodName = "test"
+	}
+	if opt.Namespace == "" {
+		opt.Namespace = "default"
+	}
+	c, err := NewClient(&opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	execs := &fakeExecs{}
+	orig := newExecutor
+	newExecutor = func(ctx context.Context, _ *rest.Config, _ TransportOptions, _ string, u *url.URL, stdin *stdinWrites) (remotecommand.Executor, error) {
+		return &localExecutor{ctx: ctx, command: u.Query()["command"], stdin: stdin, execs: execs}, nil
+	}
+	t.Cleanup(func() { newExecutor = orig })
+	return c, execs
+}
+
+// fakeAPI serves API calls from a fake clientset, except that exec requests
+// still go through the client's REST client and so to newExecutor.
+type fakeAPI struct {
+	*fake.Clientset
+	rest rest.Interface
+}
+
+func (f fakeAPI) CoreV1() typedcorev1.CoreV1Interface {
+	return fakeCoreV1{CoreV1Interface: f.Clientset.CoreV1(), rest: f.rest}
+}
+
+type fakeCoreV1 struct {
+	typedcorev1.CoreV1Interface
+	rest rest.Interface
+}
+
+func (f fakeCoreV1) RESTClient() rest.Interface {
+	return f.rest
+}
+
+// useFakeAPI makes c serve its API calls from a fake clientset holding objs.
+func useFakeAPI(c *Client, objs ...runtime.Object) {
+	c.Interface = fakeAPI{Clientset: fake.NewSimpleClientset(objs...), rest: c.CoreV1().RESTClient()}
+}
+
+// failingExecutor fails every stream with err, like a dropped connection.
+type failingExecutor struct{ err error }
+
+func (e failingExecutor) Stream(remotecommand.StreamOptions) error {
+	return e.err
+}
+
+func TestIgnoreStdinBrokenPipe(t *testing.T) {
+	// The commands exit before reading any of their stdin, so writing the
+	// rest of it fails with a broken pipe.
+	stdin := func() io.Reader { return bytes.NewReader(make([]byte, 16<<20)) }
+
+	c, _ := newTestClient(t, ClientOpt{})
+	err := c.ExecPod([]string{"sleep", "0.1"}, stdin(), nil, nil, false, time.Minute)
+	if err == nil || !strings.Contains(err.Error(), "failed to write stdin") || !brokenPipe(err) {
+		t.Fatalf("ExecPod without IgnoreStdinBrokenPipe = %v, want a broken pipe on stdin", err)
+	}
+
+	c, _ = newTestClient(t, ClientOpt{IgnoreStdinBrokenPipe: true})
+	if err := c.ExecPod([]string{"sleep", "0.1"}, stdin(), nil, nil, false, time.Minute); err != nil {
+		t.Fatalf("ExecPod with IgnoreStdinBrokenPipe = %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		if err := c.ExecPod([]string{"true"}, stdin(), nil, nil, false, time.Minute); err != nil {
+			t.Fatalf("run %d: ExecPod of exiting command with IgnoreStdinBrokenPipe = %v", i, err)
+		}
+	}
+
+	err = c.ExecPod([]string{"sh", "-c", "exit 3"}, stdin(), nil, nil, false, time.Minute)
+	if code, ok := exitCode(err); !ok || code != 3 {
+		t.Errorf("failing command with IgnoreStdinBrokenPipe = %v, want exit code 3", err)
+	}
+
+	// A broken pipe of the connection itself is a failure, not stdin.
+	orig := newExecutor
+	newExecutor = func(context.Context, *rest.Config, TransportOptions, string, *url.URL, *stdinWrites) (remotecommand.Executor, error) {
+		return failingExecutor{err: fmt.Errorf("write tcp 127.0.0.1:1: %w", syscall.EPIPE)}, nil
+	}
+	defer func() { newExecutor = orig }()
+	if err := c.ExecPod([]string{"true"}, stdin(), nil, nil, false, time.Minute); err == nil {
+		t.Error("connection broken pipe with IgnoreStdinBrokenPipe succeeded")
+	}
+}
+
+func TestExplainOnFailureSkipsProbes(t *testing.T) {
+	c, _ := newTestClient(t, ClientOpt{ExplainOnFailure: true})
+	command := []string{"sh", "-c", "echo oops >&2; exit 2"}
+
+	var explained *ExplainedError
+	err := c.ExecPod(command, nil, nil, nil, false, time.Minute)
+	if !errors.As(err, &explained) {
+		t.Fatalf("ExecPod = %v, want an *ExplainedError", err)
+	}
+	if x := explained.Explanation; !x.HasExitCode || x.ExitCode != 2 || len(x.StderrTail) != 1 || x.StderrTail[0] != "oops" {
+		t.Errorf("explanation = %+v, want exit code 2 and stderr oops", x)
+	}
+
+	_, _, err = c.capture(context.Background(), command, time.Minute)
+	if err == nil || errors.As(err, &explained) {
+		t.Errorf("internal capture = %v, want a plain error", err)
+	}
+	if ok, err := c.HasBinary(context.Background(), "no-such-binary"); ok || err != nil {
+		t.Errorf("HasBinary = %v, %v, want false, nil", ok, err)
+	}
+}
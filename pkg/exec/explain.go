@@ -0,0 +1,110 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	// explainStderrBytes and explainStderrLines bound the stderr kept for an
+	// explanation.
+	explainStderrBytes = 4 << 10
+	explainStderrLines = 5
+	// explainTimeout bounds looking up the container state for an
+	// explanation.
+	explainTimeout = 10 * time.Second
+)
+
+// FailureExplanation is what ExplainOnFailure found out about a failed exec.
+type FailureExplanation struct {
+	// ExitCode is the command's exit status; HasExitCode is false when the
+	// command didn't report one, e.g. because the stream failed.
+	ExitCode    int
+	HasExitCode bool
+	// StderrTail holds the last lines of stderr.
+	StderrTail []string
+	// ContainerState and ContainerReason are as in ContainerInfo, empty if
+	// they could not be looked up.
+	ContainerState  string
+	ContainerReason string
+	// Suggestion is a hint at what to do about the failure, if there is one.
+	Suggestion string
+}
+
+// ExplainedError is returned by execs that fail while ExplainOnFailure is
+// set. It wraps the original error.
+type ExplainedError struct {
+	Err         error
+	Explanation FailureExplanation
+}
+
+func (e *ExplainedError) Error() string {
+	if e.Explanation.Suggestion == "" {
+		return e.Err.Error()
+	}
+	return e.Err.Error() + " (" + e.Explanation.Suggestion + ")"
+}
+
+func (e *ExplainedError) Unwrap() error {
+	return e.Err
+}
+
+// suggest returns a hint for a failure given what is known about it.
+func suggest(err error, x FailureExplanation) string {
+	switch {
+	case apierrors.IsForbidden(err):
+		// Only a stream can fail here; a denial found by the preflight check
+		// fails the exec before there is anything to explain.
+		return "no permission to exec, check RBAC for the pods/exec subresource"
+	case x.ContainerReason == "CrashLoopBackOff":
+		return "container is crash looping, check its previous logs"
+	case x.ContainerState != "" && x.ContainerState != "running":
+		return fmt.Sprintf("container is %s, exec needs a running container", x.ContainerState)
+	case errors.Is(err, context.DeadlineExceeded):
+		return "the command timed out, consider a longer timeout"
+	case x.HasExitCode && x.ExitCode == 126:
+		return "the command is not executable"
+	case x.HasExitCode && x.ExitCode == 127:
+		return "the command was not found in the container"
+	case x.HasExitCode && x.ExitCode == 137:
+		return "the command was killed, possibly by the OOM killer"
+	}
+	return ""
+}
+
+// explainFailure wraps err in an ExplainedError and logs the explanation.
+// stderr is the end of the command's stderr, nil if it wasn't kept.
+func (c *Client) explainFailure(err error, container string, stderr []byte) error {
+	var x FailureExplanation
+	x.ExitCode, x.HasExitCode = exitCode(err)
+
+	if tail := strings.TrimSpace(c.decodeOutput(stderr)); tail != "" {
+		lines := strings.Split(tail, "\n")
+		if len(lines) > explainStderrLines {
+			lines = lines[len(lines)-explainStderrLines:]
+		}
+		x.StderrTail = lines
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), explainTimeout)
+	defer cancel()
+	if containers, listErr := c.ListContainers(ctx); listErr == nil {
+		for _, info := range containers {
+			if info.Name == container || (container == "" && info.Kind == ContainerKindRegular) {
+				x.ContainerState, x.ContainerReason = info.State, info.Reason
+				break
+			}
+		}
+	}
+
+	x.Suggestion = suggest(err, x)
+	log.Warn("exec failed", zap.Error(err), zap.Bool("has-exit-code", x.HasExitCode), zap.Int("exit-code", x.ExitCode), zap.Strings("stderr", x.StderrTail), zap.String("container-state", x.ContainerState), zap.String("container-reason", x.ContainerReason), zap.String("suggestion", x.Suggestion))
+	return &ExplainedError{Err: err, Explanation: x}
+}
@@ -0,0 +1,28 @@
+package exec
+
+import (
+	"fmt"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestSuggest(t *testing.T) {
+	forbidden := apierrors.NewForbidden(schema.GroupResource{Resource: "pods/exec"}, "test", fmt.Errorf("denied"))
+	for _, tt := range []struct {
+		name string
+		err  error
+		x    FailureExplanation
+		want string
+	}{
+		{"forbidden stream", fmt.Errorf("failed to exec command: %w", forbidden), FailureExplanation{}, "no permission to exec, check RBAC for the pods/exec subresource"},
+		{"crash loop", fmt.Errorf("failed"), FailureExplanation{ContainerState: "waiting", ContainerReason: "CrashLoopBackOff"}, "container is crash looping, check its previous logs"},
+		{"not found", fmt.Errorf("failed"), FailureExplanation{HasExitCode: true, ExitCode: 127, ContainerState: "running"}, "the command was not found in the container"},
+		{"plain failure", fmt.Errorf("failed"), FailureExplanation{HasExitCode: true, ExitCode: 1, ContainerState: "running"}, ""},
+	} {
+		if got := suggest(tt.err, tt.x); got != tt.want {
+			t.Errorf("%s: suggest = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statTimeout bounds each stat issued by WatchFileChanges.
+const statTimeout = 10 * time.Second
+
+// FileStat is what WatchFileChanges knows about a file in the container.
+type FileStat struct {
+	Path    string
+	Exists  bool
+	ModTime time.Time
+	Size    int64
+}
+
+// statFile stats path in the target container with "stat -c '%Y %s'", which
+// both coreutils and busybox understand.
+func (c *Client) statFile(ctx context.Context, path string) (FileStat, error) {
+	stdout, stderr, err := c.capture(ctx, []string{"stat", "-c", "%Y %s", path}, statTimeout)
+	if err != nil {
+		if _, ok := exitCode(err); ok && strings.Contains(stderr, "No such file") {
+			return FileStat{Path: path}, nil
+		}
+		return FileStat{}, withStderr(err, stderr)
+	}
+
+	fields := strings.Fields(stdout)
+	if len(fields) < 2 {
+		return FileStat{}, fmt.Errorf("unexpected stat output %q", stdout)
+	}
+	mtime, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return FileStat{}, fmt.Errorf("unexpected stat mtime %q: %w", fields[0], err)
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return FileStat{}, fmt.Errorf("unexpected stat size %q: %w", fields[1], err)
+	}
+	return FileStat{Path: path, Exists: true, ModTime: time.Unix(mtime, 0), Size: size}, nil
+}
+
+// WatchFileChanges polls path in the target container every interval and
+// calls onChange whenever its mtime or size changes, or it appears or
+// disappears. The first poll only records the initial state. An interval of
+// zero or less polls every second. It returns when ctx is done or a stat
+// fails.
+func (c *Client) WatchFileChanges(ctx context.Context, path string, interval time.Duration, onChange func(stat FileStat)) error {
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	last, err := c.statFile(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		stat, err := c.statFile(ctx, path)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if stat != last {
+			onChange(stat)
+			last = stat
+		}
+	}
+}
+
+// hashTools maps the algorithms RemoteFileHash supports to the tool computing
+// them and the length of their hex digest.
+var hashTools = map[string]struct {
+	tool string
+	size int
+}{
+	"md5":    {"md5sum", 32},
+	"sha1":   {"sha1sum", 40},
+	"sha256": {"sha256sum", 64},
+}
+
+// RemoteFileHash returns the hex digest of a file in the target container,
+// computed in place with md5sum, sha1sum or sha256sum so the file is never
+// transferred. algo is "md5", "sha1" or "sha256".
+func (c *Client) RemoteFileHash(ctx context.Context, path, algo string) (string, error) {
+	hash, ok := hashTools[strings.ToLower(algo)]
+	if !ok {
+		return "", fmt.Errorf("unsupported hash algorithm %q", algo)
+	}
+
+	found, err := c.HasBinary(ctx, hash.tool)
+	if err != nil {
+		return "", fmt.Errorf("failed to check for %s: %w", hash.tool, err)
+	}
+	if !found {
+		return "", fmt.Errorf("%w: %s is not installed in container %s", ErrCommandNotFound, hash.tool, c.ContainerName)
+	}
+
+	stdout, stderr, err := c.capture(ctx, []string{hash.tool, path}, 0)
+	if err != nil {
+		return "", withStderr(err, stderr)
+	}
+
+	fields := strings.Fields(stdout)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("unexpected %s output %q", hash.tool, stdout)
+	}
+	digest := strings.ToLower(fields[0])
+	if _, err := hex.DecodeString(digest); err != nil || len(digest) != hash.size {
+		return "", fmt.Errorf("unexpected %s output %q", hash.tool, stdout)
+	}
+	return digest, nil
+}
+
+// ReadFileFromPod returns the contents of path in the target container, read
+// with cat. The bytes are returned as is.
+func (c *Client) ReadFileFromPod(ctx context.Context, path string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	err := c.exec(ctx, execOptions{
+		command: []string{"cat", path},
+		explain: true,
+		stdout:  &stdout,
+		stderr:  &stderr,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, withStderr(err, stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// DiffRemoteFile returns a unified diff from the file at remotePath in the
+// target container to the local file at localPath, e.g. to spot drift between
+// a deployed config and its source. It returns "" when they are equal, and
+// "Binary files ... differ" instead of a diff when either side is binary.
+func (c *Client) DiffRemoteFile(ctx context.Context, remotePath, localPath string) (string, error) {
+	local, err := os.ReadFile(localPath)
+	if err != nil {
+		return "", err
+	}
+	remote, err := c.ReadFileFromPod(ctx, remotePath)
+	if err != nil {
+		return "", err
+	}
+
+	podName, err := c.podName(ctx)
+	if err != nil {
+		return "", err
+	}
+	remoteName := fmt.Sprintf("%s/%s:%s", c.Namespace, podName, remotePath)
+	if isBinary(remote) || isBinary(local) {
+		if bytes.Equal(remote, local) {
+			return "", nil
+		}
+		return fmt.Sprintf("Binary files %s and %s differ\n", remoteName, localPath), nil
+	}
+	return unifiedDiff(remoteName, localPath, string(remote), string(local)), nil
+}
+
+// ansiEscape matches ANSI CSI and OSC escape sequences.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)`)
+
+// normalizeGolden strips ANSI escapes, carriage returns and trailing
+// whitespace from output and ends it with a single newline, so golden files
+// don't depend on terminal styling or stray spaces.
+func normalizeGolden(output string) string {
+	output = ansiEscape.ReplaceAllString(output, "")
+	lines := strings.Split(strings.ReplaceAll(output, "\r", ""), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	output = strings.TrimRight(strings.Join(lines, "\n"), "\n")
+	if output == "" {
+		return ""
+	}
+	return output + "\n"
+}
+
+// ExecPodAssertGolden runs command and compares its normalized stdout with
+// the golden file at goldenPath, e.g. for in-cluster smoke tests. A mismatch
+// fails with a unified diff from the golden file to the output. With update
+// set the golden file is rewritten with the output instead.
+func (c *Client) ExecPodAssertGolden(command []string, goldenPath string, update bool, timeout time.Duration) error {
+	stdout, stderr, err := c.captureExplained(context.Background(), command, timeout)
+	if err != nil {
+		return withStderr(err, stderr)
+	}
+	got := normalizeGolden(stdout)
+
+	if update {
+		return os.WriteFile(goldenPath, []byte(got), 0o644)
+	}
+
+	want, err := os.ReadFile(goldenPath)
+	if err != nil {
+		return fmt.Errorf("failed to read golden file: %w", err)
+	}
+	if diff := unifiedDiff(goldenPath, "output", normalizeGolden(string(want)), got); diff != "" {
+		return fmt.Errorf("output of %q does not match %s:\n%s", strings.Join(command, " "), goldenPath, diff)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	// frameProbeMin and frameProbeMax bound the payload sizes, before base64
+	// encoding, ExecPodMaxFrameSize tries.
+	frameProbeMin = 1 << 10
+	frameProbeMax = 1 << 20
+	// frameProbeTimeout bounds each probe exec.
+	frameProbeTimeout = 30 * time.Second
+)
+
+// base64Counter counts the base64 characters written to it, ignoring the
+// line breaks base64(1) inserts.
+type base64Counter struct {
+	n int
+}
+
+func (w *base64Counter) Write(p []byte) (int, error) {
+	w.n += len(p) - bytes.Count(p, []byte("\n")) - bytes.Count(p, []byte("\r"))
+	return len(p), nil
+}
+
+// ExecPodMaxFrameSize finds the largest output, in bytes before base64
+// encoding, that makes it through the exec path intact. It runs
+// "head -c N /dev/zero | base64" for N doubling from 1KiB and checks that
+// the full encoding arrives, stopping at the first that doesn't or at 1MiB.
+// This helps to spot proxies that truncate large frames, and the result is a
+// safe chunk size for copying data through exec.
+func (c *Client) ExecPodMaxFrameSize(ctx context.Context) (int, error) {
+	safe := 0
+	for size := frameProbeMin; size <= frameProbeMax; size *= 2 {
+		out := &base64Counter{}
+		var stderr bytes.Buffer
+		err := c.exec(ctx, execOptions{
+			command: shellCommand("head -c " + strconv.Itoa(size) + " /dev/zero | base64"),
+			stdout:  out,
+			stderr:  &stderr,
+			timeout: frameProbeTimeout,
+		})
+		if err != nil {
+			if ctx.Err() != nil || safe == 0 {
+				return safe, fmt.Errorf("frame size probe of %d bytes failed: %w", size, withStderr(err, stderr.String()))
+			}
+			break
+		}
+		if want := (size + 2) / 3 * 4; out.n != want {
+			if safe == 0 {
+				return 0, fmt.Errorf("frame size probe of %d bytes received %d of %d base64 characters", size, out.n, want)
+			}
+			break
+		}
+		safe = size
+	}
+	return safe, nil
+}
@@ -0,0 +1,113 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// ErrOutputLimit is returned when a command wrote more output than allowed.
+var ErrOutputLimit = fmt.Errorf("output limit exceeded")
+
+// GuardOptions describe the safety wrappers ExecPodGuarded puts around a
+// command.
+type GuardOptions struct {
+	// RemoteTimeout runs the command under timeout(1) in the container so it
+	// is killed there even if the stream is lost. Zero disables it.
+	RemoteTimeout time.Duration
+	// Nice runs the command under nice(1) with this adjustment. Zero disables
+	// it.
+	Nice int
+	// MaxOutputBytes aborts the command once it has written more than this
+	// many bytes to stdout and stderr combined. Zero means no limit.
+	MaxOutputBytes int
+
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// outputLimit counts the bytes written through its writers and, once more
+// than limit have gone through, fails further writes and cancels the stream.
+type outputLimit struct {
+	mu       sync.Mutex
+	written  int
+	limit    int
+	exceeded bool
+	cancel   context.CancelFunc
+}
+
+// writer returns an io.Writer that counts against l and forwards to w, which
+// may be nil.
+func (l *outputLimit) writer(w io.Writer) io.Writer {
+	return &limitedWriter{l: l, w: w}
+}
+
+type limitedWriter struct {
+	l *outputLimit
+	w io.Writer
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	lw.l.mu.Lock()
+	if lw.l.limit > 0 && lw.l.written+len(p) > lw.l.limit {
+		lw.l.exceeded = true
+		lw.l.mu.Unlock()
+		lw.l.cancel()
+		return 0, ErrOutputLimit
+	}
+	lw.l.written += len(p)
+	lw.l.mu.Unlock()
+
+	if lw.w == nil {
+		return len(p), nil
+	}
+	return lw.w.Write(p)
+}
+
+// ExecPodGuarded runs command wrapped as configured by opts, so potentially
+// expensive diagnostics can be run on production pods with bounded impact.
+// If timeout or nice is missing from the container the corresponding wrapper
+// is skipped with a warning rather than failing the command.
+func (c *Client) ExecPodGuarded(command []string, opts GuardOptions, timeout time.Duration) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wrapped := command
+	if opts.Nice != 0 {
+		if ok, err := c.HasBinary(ctx, "nice"); err == nil && ok {
+			wrapped = append([]string{"nice", "-n", strconv.Itoa(opts.Nice)}, wrapped...)
+		} else {
+			log.Warn("nice is not available, running without it", zap.Strings("command", command), zap.Error(err))
+		}
+	}
+	if opts.RemoteTimeout > 0 {
+		if ok, err := c.HasBinary(ctx, "timeout"); err == nil && ok {
+			seconds := int((opts.RemoteTimeout + time.Second - 1) / time.Second)
+			wrapped = append([]string{"timeout", strconv.Itoa(seconds)}, wrapped...)
+		} else {
+			log.Warn("timeout is not available, running without a remote timeout", zap.Strings("command", command), zap.Error(err))
+		}
+	}
+
+	limit := &outputLimit{limit: opts.MaxOutputBytes, cancel: cancel}
+	err := c.exec(ctx, execOptions{
+		command: wrapped,
+		explain: true,
+		stdout:  limit.writer(opts.Stdout),
+		stderr:  limit.writer(opts.Stderr),
+		timeout: timeout,
+	})
+	limit.mu.Lock()
+	exceeded := limit.exceeded
+	limit.mu.Unlock()
+	if exceeded {
+		return fmt.Errorf("%w: more than %d bytes", ErrOutputLimit, opts.MaxOutputBytes)
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+)
+
+// ErrStreamLimit is returned by a fail-fast StreamLimiter that has no free
+// stream.
+var ErrStreamLimit = fmt.Errorf("exec stream limit reached")
+
+// StreamLimitMode decides what an exec does when its StreamLimiter is full.
+type StreamLimitMode int
+
+const (
+	// StreamLimitBlock waits for a free stream or for the exec's context to
+	// be done.
+	StreamLimitBlock StreamLimitMode = iota
+	// StreamLimitFailFast fails the exec with ErrStreamLimit right away.
+	StreamLimitFailFast
+)
+
+// StreamLimiter caps the number of exec streams open at once across every
+// Client that shares it, e.g. to protect an API server used by a shared exec
+// service. It applies on top of each client's MaxConcurrentStreams.
+type StreamLimiter struct {
+	slots chan struct{}
+	mode  StreamLimitMode
+}
+
+// NewStreamLimiter returns a StreamLimiter allowing max concurrent streams.
+func NewStreamLimiter(max int, mode StreamLimitMode) (*StreamLimiter, error) {
+	if max <= 0 {
+		return nil, fmt.Errorf("invalid stream limit %d", max)
+	}
+	return &StreamLimiter{slots: make(chan struct{}, max), mode: mode}, nil
+}
+
+// acquire takes a stream slot. The returned func gives it back. A nil
+// limiter doesn't limit.
+func (l *StreamLimiter) acquire(ctx context.Context) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	release := func() { <-l.slots }
+	if l.mode == StreamLimitFailFast {
+		select {
+		case l.slots <- struct{}{}:
+			return release, nil
+		default:
+			return nil, fmt.Errorf("%w: %d streams open", ErrStreamLimit, cap(l.slots))
+		}
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for a free shared exec stream: %w", ctx.Err())
+	}
+}
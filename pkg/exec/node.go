@@ -0,0 +1,304 @@
+package exec
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+)
+
+const (
+	// nodeHelperLabel marks the helper pods ExecOnNode creates; its value is
+	// the node the helper runs on.
+	nodeHelperLabel = "k8sutils.qiffang.io/node-helper"
+	// nodeHelperContainer is the name of the helper pod's container.
+	nodeHelperContainer = "node-helper"
+	// nodeHelperOwnerAnnotation names the ExecOnNode call using a helper pod,
+	// and nodeHelperLeaseAnnotation holds until when, in RFC 3339. The call
+	// renews the lease every nodeHelperLease/3 while it runs.
+	nodeHelperOwnerAnnotation = "k8sutils.qiffang.io/node-helper-owner"
+	nodeHelperLeaseAnnotation = "k8sutils.qiffang.io/node-helper-lease"
+	// nodeHelperLease is how long a helper stays claimed without renewal.
+	nodeHelperLease = time.Minute
+)
+
+// ErrPrivilegedHelpersDisabled is returned by ExecOnNode unless
+// AllowPrivilegedHelpers is set.
+var ErrPrivilegedHelpersDisabled = fmt.Errorf("privileged helper pods are not allowed, set AllowPrivilegedHelpers")
+
+// ExecOnNode runs command on the node the target pod is scheduled on, e.g.
+// to debug the node under a misbehaving pod. It runs the command in a
+// privileged helper pod of helperImage on that node, sharing the host's
+// network, IPC and PID namespaces, and enters the host's mount, UTS, IPC,
+// network and PID namespaces with nsenter, so the image needs nsenter.
+//
+// Helper pods are labeled with the node they run on and leased to the call
+// using them, which renews the lease while the command runs. A running helper
+// of the same image whose lease has expired, e.g. one left behind by an
+// interrupted run, is reclaimed, and ended helpers whose lease has expired are
+// deleted; otherwise a new one is created in the client's namespace. Helpers
+// leased to other calls are left alone, so concurrent calls for the same node
+// each get their own. The helper is deleted again before returning. Leases
+// are compared with the local clock, so clients with skewed clocks may
+// reclaim a helper early or late. Since this creates privileged pods it must
+// be enabled with AllowPrivilegedHelpers.
+func (c *Client) ExecOnNode(ctx context.Context, command []string, helperImage string, stdout, stderr io.Writer) error {
+	if !c.AllowPrivilegedHelpers {
+		return ErrPrivilegedHelpersDisabled
+	}
+	if len(command) == 0 {
+		return fmt.Errorf("empty command")
+	}
+
+	pod, err := c.getPod(ctx)
+	if err != nil {
+		return err
+	}
+	node := pod.Spec.NodeName
+	if node == "" {
+		return fmt.Errorf("pod %s/%s is not scheduled to a node yet", pod.Namespace, pod.Name)
+	}
+
+	owner := utilrand.String(10)
+	helper, err := c.claimNodeHelper(ctx, node, helperImage, owner)
+	if err != nil {
+		return err
+	}
+	created := helper == ""
+	if created {
+		if helper, err = c.createNodeHelper(ctx, node, helperImage, owner); err != nil {
+			return err
+		}
+	} else {
+		log.Info("reclaimed node helper pod", zap.String("namespace", c.Namespace), zap.String("pod", helper), zap.String("node", node))
+	}
+	defer c.deleteNodeHelper(helper)
+	stopRenewing := c.renewNodeHelperLease(helper, owner)
+	defer stopRenewing()
+
+	if created {
+		if err := c.waitForPodRunning(ctx, helper); err != nil {
+			return err
+		}
+	}
+
+	return c.exec(ctx, execOptions{
+		pod:       helper,
+		container: nodeHelperContainer,
+		command:   append([]string{"nsenter", "-t", "1", "-m", "-u", "-i", "-n", "-p", "--"}, command...),
+		stdout:    stdout,
+		stderr:    stderr,
+	})
+}
+
+// claimNodeHelper leases a running helper pod of image on node whose lease
+// has expired to owner and returns its name, or "" if there is none. Ended
+// helpers whose lease has expired are deleted along the way. Claims and
+// deletions are conditional on the pod being unchanged since it was listed,
+// so concurrent calls never both take the same helper.
+func (c *Client) claimNodeHelper(ctx context.Context, node, image, owner string) (string, error) {
+	pods, err := c.CoreV1().Pods(c.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: labels.Set{nodeHelperLabel: node}.String(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list node helper pods: %w", err)
+	}
+	now := time.Now()
+	found := ""
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.DeletionTimestamp != nil || !nodeHelperLeaseExpired(pod, now) {
+			continue
+		}
+		switch pod.Status.Phase {
+		case corev1.PodFailed, corev1.PodSucceeded:
+			c.deleteEndedNodeHelper(ctx, pod)
+		case corev1.PodRunning:
+			if found != "" || len(pod.Spec.Containers) == 0 || pod.Spec.Containers[0].Image != image {
+				continue
+			}
+			pod.Annotations = nodeHelperLeaseAnnotations(pod.Annotations, owner, now)
+			_, err := c.CoreV1().Pods(c.Namespace).Update(ctx, pod, metav1.UpdateOptions{})
+			switch {
+			case err == nil:
+				found = pod.Name
+			case apierrors.IsConflict(err) || apierrors.IsNotFound(err):
+				// Another call claimed or deleted it first.
+			default:
+				return "", fmt.Errorf("failed to claim node helper pod %s/%s: %w", c.Namespace, pod.Name, err)
+			}
+		}
+	}
+	return found, nil
+}
+
+// nodeHelperLeaseExpired reports whether no call holds pod's lease at now.
+// Helpers without a readable lease count as expired.
+func nodeHelperLeaseExpired(pod *corev1.Pod, now time.Time) bool {
+	until, err := time.Parse(time.RFC3339, pod.Annotations[nodeHelperLeaseAnnotation])
+	return err != nil || !now.Before(until)
+}
+
+// nodeHelperLeaseAnnotations returns annotations with the helper leased to
+// owner from now on.
+func nodeHelperLeaseAnnotations(annotations map[string]string, owner string, now time.Time) map[string]string {
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[nodeHelperOwnerAnnotation] = owner
+	annotations[nodeHelperLeaseAnnotation] = now.Add(nodeHelperLease).UTC().Format(time.RFC3339)
+	return annotations
+}
+
+// deleteEndedNodeHelper deletes an ended helper pod unless it changed since it
+// was listed, e.g. because another call claimed it.
+func (c *Client) deleteEndedNodeHelper(ctx context.Context, pod *corev1.Pod) {
+	var grace int64
+	err := c.CoreV1().Pods(c.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{
+		GracePeriodSeconds: &grace,
+		Preconditions:      &metav1.Preconditions{UID: &pod.UID, ResourceVersion: &pod.ResourceVersion},
+	})
+	switch {
+	case err == nil:
+		log.Info("deleted ended node helper pod", zap.String("namespace", c.Namespace), zap.String("pod", pod.Name), zap.String("phase", string(pod.Status.Phase)))
+	case apierrors.IsConflict(err) || apierrors.IsNotFound(err):
+	default:
+		log.Warn("failed to delete ended node helper pod", zap.String("namespace", c.Namespace), zap.String("pod", pod.Name), zap.Error(err))
+	}
+}
+
+// renewNodeHelperLease keeps the named helper leased to owner until the
+// returned func is called. Each renewal is a JSON patch that tests the owner
+// first, so it never extends a lease another call has taken over.
+func (c *Client) renewNodeHelperLease(name, owner string) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(nodeHelperLease / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if err := c.patchNodeHelperLease(ctx, name, owner, time.Now()); err != nil && ctx.Err() == nil {
+				log.Warn("failed to renew node helper pod lease", zap.String("namespace", c.Namespace), zap.String("pod", name), zap.Error(err))
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func (c *Client) patchNodeHelperLease(ctx context.Context, name, owner string, now time.Time) error {
+	annotation := func(key string) string {
+		return "/metadata/annotations/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+	}
+	patch, err := json.Marshal([]map[string]string{
+		{"op": "test", "path": annotation(nodeHelperOwnerAnnotation), "value": owner},
+		{"op": "replace", "path": annotation(nodeHelperLeaseAnnotation), "value": now.Add(nodeHelperLease).UTC().Format(time.RFC3339)},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.CoreV1().Pods(c.Namespace).Patch(ctx, name, types.JSONPatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// createNodeHelper creates a privileged helper pod of image on node, leased
+// to owner.
+func (c *Client) createNodeHelper(ctx context.Context, node, image, owner string) (string, error) {
+	privileged := true
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "node-helper-" + utilrand.String(5),
+			Labels:      map[string]string{nodeHelperLabel: node},
+			Annotations: nodeHelperLeaseAnnotations(nil, owner, time.Now()),
+		},
+		Spec: corev1.PodSpec{
+			NodeName:      node,
+			HostPID:       true,
+			HostIPC:       true,
+			HostNetwork:   true,
+			RestartPolicy: corev1.RestartPolicyNever,
+			Tolerations:   []corev1.Toleration{{Operator: corev1.TolerationOpExists}},
+			Containers: []corev1.Container{{
+				Name:            nodeHelperContainer,
+				Image:           image,
+				Command:         debugKeepalive,
+				ImagePullPolicy: corev1.PullIfNotPresent,
+				SecurityContext: &corev1.SecurityContext{Privileged: &privileged},
+			}},
+		},
+	}
+
+	log.Info("creating privileged node helper pod", zap.String("namespace", c.Namespace), zap.String("pod", pod.Name), zap.String("node", node), zap.String("image", image))
+	created, err := c.CoreV1().Pods(c.Namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		if apierrors.IsForbidden(err) {
+			return "", fmt.Errorf("no permissions to create privileged helper pods in namespace %s: %w", c.Namespace, err)
+		}
+		return "", fmt.Errorf("failed to create node helper pod: %w", err)
+	}
+	return created.Name, nil
+}
+
+// deleteNodeHelper deletes a helper pod this call created or claimed. It uses
+// its own context, so the pod is cleaned up even when the caller's context
+// is already done.
+func (c *Client) deleteNodeHelper(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+
+	var grace int64
+	err := c.CoreV1().Pods(c.Namespace).Delete(ctx, name, metav1.DeleteOptions{GracePeriodSeconds: &grace})
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Warn("failed to delete node helper pod", zap.String("namespace", c.Namespace), zap.String("pod", name), zap.Error(err))
+		return
+	}
+	log.Info("deleted node helper pod", zap.String("namespace", c.Namespace), zap.String("pod", name))
+}
+
+// waitForPodRunning polls the named pod until it runs, fails or
+// debugStartTimeout elapses.
+func (c *Client) waitForPodRunning(ctx context.Context, name string) error {
+	ctx, cancel := context.WithTimeout(ctx, debugStartTimeout)
+	defer cancel()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		pod, err := c.CoreV1().Pods(c.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("failed to get pod %s/%s: %w", c.Namespace, name, err)
+		}
+		switch pod.Status.Phase {
+		case corev1.PodRunning:
+			return nil
+		case corev1.PodFailed, corev1.PodSucceeded:
+			return fmt.Errorf("pod %s/%s ended before it could be used: %s", c.Namespace, name, pod.Status.Phase)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for pod %s/%s: %w", c.Namespace, name, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
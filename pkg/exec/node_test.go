@@ -0,0 +1,81 @@
+package exec
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func nodeHelperPod(name, image string, phase corev1.PodPhase, lease string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{nodeHelperLabel: "node-1"},
+		},
+		Spec:   corev1.PodSpec{Containers: []corev1.Container{{Name: nodeHelperContainer, Image: image}}},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+	if lease != "" {
+		pod.Annotations = map[string]string{nodeHelperOwnerAnnotation: "other", nodeHelperLeaseAnnotation: lease}
+	}
+	return pod
+}
+
+func TestClaimNodeHelper(t *testing.T) {
+	expired := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	held := time.Now().Add(time.Minute).UTC().Format(time.RFC3339)
+
+	c, _ := newTestClient(t, ClientOpt{})
+	useFakeAPI(c,
+		nodeHelperPod("held", "img", corev1.PodRunning, held),
+		nodeHelperPod("other-image", "other", corev1.PodRunning, expired),
+		nodeHelperPod("orphan", "img", corev1.PodRunning, expired),
+		nodeHelperPod("ended-held", "img", corev1.PodSucceeded, held),
+		nodeHelperPod("ended-orphan", "img", corev1.PodFailed, ""),
+	)
+	ctx := context.Background()
+
+	got, err := c.claimNodeHelper(ctx, "node-1", "img", "me")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "orphan" {
+		t.Fatalf("claimNodeHelper = %q, want orphan", got)
+	}
+	pods := c.CoreV1().Pods("default")
+	orphan, err := pods.Get(ctx, "orphan", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner := orphan.Annotations[nodeHelperOwnerAnnotation]; owner != "me" || nodeHelperLeaseExpired(orphan, time.Now()) {
+		t.Errorf("claimed helper annotations = %v, want a lease held by me", orphan.Annotations)
+	}
+	for name, kept := range map[string]bool{"held": true, "other-image": true, "ended-held": true, "ended-orphan": false} {
+		_, err := pods.Get(ctx, name, metav1.GetOptions{})
+		if (err == nil) != kept {
+			t.Errorf("helper %s kept = %v, want %v", name, err == nil, kept)
+		}
+	}
+
+	if got, err := c.claimNodeHelper(ctx, "node-1", "img", "someone-else"); err != nil || got != "" {
+		t.Errorf("second claimNodeHelper = %q, %v, want no helper", got, err)
+	}
+
+	if err := c.patchNodeHelperLease(ctx, "held", "me", time.Now()); err == nil {
+		t.Error("renewing a lease held by another owner succeeded")
+	}
+	later := time.Now().Add(time.Hour)
+	if err := c.patchNodeHelperLease(ctx, "orphan", "me", later); err != nil {
+		t.Fatalf("renewing own lease = %v", err)
+	}
+	if orphan, err = pods.Get(ctx, "orphan", metav1.GetOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if nodeHelperLeaseExpired(orphan, later) {
+		t.Errorf("renewed lease = %s, want it past %s", orphan.Annotations[nodeHelperLeaseAnnotation], later)
+	}
+}
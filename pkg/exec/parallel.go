@@ -0,0 +1,58 @@
+package exec
+
+import (
+	"context"
+	"sync"
+)
+
+// parallelExecLimit bounds how many streams ExecPodParallel opens at once.
+const parallelExecLimit = 4
+
+// ExecResult is the outcome of one command run by ExecPodParallel.
+type ExecResult struct {
+	Command []string
+	Stdout  string
+	Stderr  string
+	// ExitCode is the remote exit status; it is only meaningful when Err is
+	// nil or carries an exit status.
+	ExitCode int
+	Err      error
+}
+
+// ExecPodParallel runs independent commands concurrently in the target
+// container, each in its own exec stream, and returns their results in the
+// order of commands. Every command opens a separate stream to the same pod,
+// so it is best suited to read-only diagnostics.
+func (c *Client) ExecPodParallel(ctx context.Context, commands [][]string) []ExecResult {
+	results := make([]ExecResult, len(commands))
+	sem := make(chan struct{}, parallelExecLimit)
+
+	var wg sync.WaitGroup
+	for i, command := range commands {
+		wg.Add(1)
+		go func(i int, command []string) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				results[i] = ExecResult{Command: command, Err: ctx.Err()}
+				return
+			}
+
+			stdout, stderr, err := c.captureExplained(ctx, command, 0)
+			code, _ := exitCode(err)
+			results[i] = ExecResult{
+				Command:  command,
+				Stdout:   stdout,
+				Stderr:   stderr,
+				ExitCode: code,
+				Err:      err,
+			}
+		}(i, command)
+	}
+	wg.Wait()
+
+	return results
+}
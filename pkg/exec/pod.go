@@ -0,0 +1,325 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// getPod fetches the pod the client is targeting.
+func (c *Client) getPod(ctx context.Context) (*corev1.Pod, error) {
+	name, err := c.podName(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pod, err := c.CoreV1().Pods(c.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod %s/%s: %w", c.Namespace, name, err)
+	}
+	return pod, nil
+}
+
+// targetContainer returns the spec of the container the client is targeting.
+// When no container name is configured the first container in the pod is
+// used, matching kubectl's default.
+func (c *Client) targetContainer(pod *corev1.Pod) (*corev1.Container, error) {
+	if c.ContainerName == "" {
+		if len(pod.Spec.Containers) == 0 {
+			return nil, fmt.Errorf("pod %s/%s has no containers", pod.Namespace, pod.Name)
+		}
+		return &pod.Spec.Containers[0], nil
+	}
+	for i := range pod.Spec.Containers {
+		if pod.Spec.Containers[i].Name == c.ContainerName {
+			return &pod.Spec.Containers[i], nil
+		}
+	}
+	return nil, fmt.Errorf("container %s not found in pod %s/%s", c.ContainerName, pod.Namespace, pod.Name)
+}
+
+// containerStatus returns the status of the named container, or nil if the
+// kubelet has not reported it yet.
+func containerStatus(pod *corev1.Pod, name string) *corev1.ContainerStatus {
+	return findStatus(pod.Status.ContainerStatuses, name)
+}
+
+// ContainerImage returns the image configured for the target container and
+// the imageID (digest) the kubelet resolved it to. If the container status is
+// not populated yet, the spec image is returned and imageID is empty.
+func (c *Client) ContainerImage(ctx context.Context) (image string, imageID string, err error) {
+	pod, err := c.getPod(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	container, err := c.targetContainer(pod)
+	if err != nil {
+		return "", "", err
+	}
+
+	status := containerStatus(pod, container.Name)
+	if status == nil {
+		return container.Image, "", nil
+	}
+	return container.Image, status.ImageID, nil
+}
+
+// PodServiceAccount returns the service account the target pod runs as. Pods
+// that don't set one run as "default".
+func (c *Client) PodServiceAccount(ctx context.Context) (string, error) {
+	pod, err := c.getPod(ctx)
+	if err != nil {
+		return "", err
+	}
+	if pod.Spec.ServiceAccountName == "" {
+		return "default", nil
+	}
+	return pod.Spec.ServiceAccountName, nil
+}
+
+// ContainerRuntime reports the runtime of the target container as given by
+// the scheme of its status ContainerID, e.g. "containerd", "cri-o" or
+// "docker". It fails if the container has not been started yet.
+func (c *Client) ContainerRuntime(ctx context.Context) (string, error) {
+	pod, err := c.getPod(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	container, err := c.targetContainer(pod)
+	if err != nil {
+		return "", err
+	}
+
+	status := containerStatus(pod, container.Name)
+	if status == nil || status.ContainerID == "" {
+		return "", fmt.Errorf("container %s in pod %s/%s has not started yet", container.Name, pod.Namespace, pod.Name)
+	}
+
+	runtime, _, ok := strings.Cut(status.ContainerID, "://")
+	if !ok {
+		return "", fmt.Errorf("unexpected container ID %q", status.ContainerID)
+	}
+	return runtime, nil
+}
+
+// PodRef identifies a pod.
+type PodRef struct {
+	Namespace string
+	Name      string
+}
+
+// ListExecablePodsAllNamespaces lists the running pods of every namespace
+// that the current user may exec into. It needs permission to list pods
+// cluster-wide; exec permission is checked once per namespace, and
+// namespaces where it is denied are left out.
+func (c *Client) ListExecablePodsAllNamespaces(ctx context.Context) ([]PodRef, error) {
+	pods, err := c.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods in all namespaces: %w", err)
+	}
+
+	allowed := make(map[string]bool)
+	var refs []PodRef
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+
+		ok, checked := allowed[pod.Namespace]
+		if !checked {
+			err := c.canExecIn(ctx, pod.Namespace)
+			if err != nil && !errors.Is(err, deniedCreateExecErr) {
+				return nil, fmt.Errorf("failed to check exec permissions in namespace %s: %w", pod.Namespace, err)
+			}
+			ok = err == nil
+			allowed[pod.Namespace] = ok
+		}
+		if ok {
+			refs = append(refs, PodRef{Namespace: pod.Namespace, Name: pod.Name})
+		}
+	}
+	return refs, nil
+}
+
+// ContainerKind tells the regular, init and ephemeral containers of a pod
+// apart.
+type ContainerKind string
+
+const (
+	ContainerKindRegular   ContainerKind = "regular"
+	ContainerKindInit      ContainerKind = "init"
+	ContainerKindEphemeral ContainerKind = "ephemeral"
+)
+
+// ContainerInfo describes one container of a pod.
+type ContainerInfo struct {
+	Name  string
+	Kind  ContainerKind
+	Image string
+	// State is "waiting", "running" or "terminated", or "" if the kubelet
+	// has not reported the container yet.
+	State string
+	// Reason explains a waiting or terminated state, e.g. "CrashLoopBackOff"
+	// or "Completed".
+	Reason string
+}
+
+// containerState summarizes a container status for ContainerInfo.
+func containerState(status *corev1.ContainerStatus) (state, reason string) {
+	switch {
+	case status == nil:
+		return "", ""
+	case status.State.Running != nil:
+		return "running", ""
+	case status.State.Terminated != nil:
+		return "terminated", status.State.Terminated.Reason
+	case status.State.Waiting != nil:
+		return "waiting", status.State.Waiting.Reason
+	}
+	return "", ""
+}
+
+// findStatus returns the status of the named container in statuses, or nil.
+func findStatus(statuses []corev1.ContainerStatus, name string) *corev1.ContainerStatus {
+	for i := range statuses {
+		if statuses[i].Name == name {
+			return &statuses[i]
+		}
+	}
+	return nil
+}
+
+// ListContainers returns the init, regular and ephemeral containers of the
+// target pod, in that order, with their current state.
+func (c *Client) ListContainers(ctx context.Context) ([]ContainerInfo, error) {
+	pod, err := c.getPod(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var containers []ContainerInfo
+	add := func(kind ContainerKind, name, image string, statuses []corev1.ContainerStatus) {
+		state, reason := containerState(findStatus(statuses, name))
+		containers = append(containers, ContainerInfo{Name: name, Kind: kind, Image: image, State: state, Reason: reason})
+	}
+	for _, container := range pod.Spec.InitContainers {
+		add(ContainerKindInit, container.Name, container.Image, pod.Status.InitContainerStatuses)
+	}
+	for _, container := range pod.Spec.Containers {
+		add(ContainerKindRegular, container.Name, container.Image, pod.Status.ContainerStatuses)
+	}
+	for _, container := range pod.Spec.EphemeralContainers {
+		add(ContainerKindEphemeral, container.Name, container.Image, pod.Status.EphemeralContainerStatuses)
+	}
+	return containers, nil
+}
+
+// ExecInitContainer runs command in the init container name of the target
+// pod, e.g. to debug an init container that is stuck. Init containers can
+// only be exec'd into while they run, so it fails with a descriptive error
+// if the container has already completed or is not running yet.
+func (c *Client) ExecInitContainer(ctx context.Context, name string, command []string, stdin io.Reader, stdout, stderr io.Writer, timeout time.Duration) error {
+	containers, err := c.ListContainers(ctx)
+	if err != nil {
+		return err
+	}
+
+	var info *ContainerInfo
+	for i := range containers {
+		if containers[i].Kind == ContainerKindInit && containers[i].Name == name {
+			info = &containers[i]
+			break
+		}
+	}
+	if info == nil {
+		return fmt.Errorf("init container %s not found", name)
+	}
+
+	switch info.State {
+	case "running":
+	case "terminated":
+		return fmt.Errorf("init container %s has already completed (%s), it can no longer be exec'd into", name, info.Reason)
+	default:
+		return fmt.Errorf("init container %s is not running (state %q, reason %q)", name, info.State, info.Reason)
+	}
+
+	return c.exec(ctx, execOptions{
+		container: name,
+		command:   command,
+		explain:   true,
+		stdin:     stdin,
+		stdout:    stdout,
+		stderr:    stderr,
+		timeout:   timeout,
+	})
+}
+
+// VolumeMount is a volume mount of the target container together with what
+// backs it.
+type VolumeMount struct {
+	corev1.VolumeMount
+	// SourceType is the kind of the mounted volume: "configMap", "secret",
+	// "persistentVolumeClaim", "emptyDir", "hostPath", "projected",
+	// "downwardAPI" or "other", or "" if the pod spec has no such volume.
+	SourceType string
+	// SourceName names the ConfigMap, Secret or PersistentVolumeClaim, or the
+	// host path, backing the volume. It is empty for other volume types.
+	SourceName string
+}
+
+// volumeSource describes what backs volume.
+func volumeSource(volume corev1.Volume) (sourceType, sourceName string) {
+	switch {
+	case volume.ConfigMap != nil:
+		return "configMap", volume.ConfigMap.Name
+	case volume.Secret != nil:
+		return "secret", volume.Secret.SecretName
+	case volume.PersistentVolumeClaim != nil:
+		return "persistentVolumeClaim", volume.PersistentVolumeClaim.ClaimName
+	case volume.HostPath != nil:
+		return "hostPath", volume.HostPath.Path
+	case volume.EmptyDir != nil:
+		return "emptyDir", ""
+	case volume.Projected != nil:
+		return "projected", ""
+	case volume.DownwardAPI != nil:
+		return "downwardAPI", ""
+	}
+	return "other", ""
+}
+
+// ContainerVolumeMounts returns the volume mounts of the target container,
+// each with the ConfigMap, Secret, PVC or other volume backing it, so tools
+// can find mounted configuration without hardcoding paths.
+func (c *Client) ContainerVolumeMounts(ctx context.Context) ([]VolumeMount, error) {
+	pod, err := c.getPod(ctx)
+	if err != nil {
+		return nil, err
+	}
+	container, err := c.targetContainer(pod)
+	if err != nil {
+		return nil, err
+	}
+
+	volumes := make(map[string]corev1.Volume, len(pod.Spec.Volumes))
+	for _, volume := range pod.Spec.Volumes {
+		volumes[volume.Name] = volume
+	}
+
+	mounts := make([]VolumeMount, 0, len(container.VolumeMounts))
+	for _, mount := range container.VolumeMounts {
+		m := VolumeMount{VolumeMount: mount}
+		if volume, ok := volumes[mount.Name]; ok {
+			m.SourceType, m.SourceName = volumeSource(volume)
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
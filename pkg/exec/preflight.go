@@ -0,0 +1,68 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// PreflightMode selects whether execs are preceded by a CanExec style
+// permission check.
+type PreflightMode int
+
+const (
+	// PreflightOff skips the check.
+	PreflightOff PreflightMode = iota
+	// PreflightWarn logs a failed check and runs the exec anyway.
+	PreflightWarn
+	// PreflightEnforce fails the exec when the check fails.
+	PreflightEnforce
+)
+
+const (
+	// preflightTimeout bounds a single permission check.
+	preflightTimeout = 10 * time.Second
+	// preflightCacheTTL is how long the outcome of a check is reused.
+	preflightCacheTTL = time.Minute
+)
+
+// preflightResult is a cached permission check outcome; err is nil when exec
+// is allowed.
+type preflightResult struct {
+	err error
+}
+
+// preflight runs the permission check selected by the Preflight option.
+// Allowed and denied outcomes are cached per namespace, so a busy client only
+// asks the API server once in a while.
+func (c *Client) preflight(ctx context.Context) error {
+	if c.Preflight == PreflightOff {
+		return nil
+	}
+
+	key := "preflight\x00" + c.Namespace
+	var err error
+	if result, ok := c.cache.get(key); ok {
+		err = result.(preflightResult).err
+	} else {
+		checkCtx, cancel := context.WithTimeout(ctx, preflightTimeout)
+		err = c.canExecIn(checkCtx, c.Namespace)
+		cancel()
+		if err == nil || errors.Is(err, deniedCreateExecErr) {
+			c.cache.set(key, preflightResult{err: err}, preflightCacheTTL)
+		}
+	}
+	if err == nil {
+		return nil
+	}
+
+	if c.Preflight == PreflightWarn {
+		log.Warn("exec preflight failed, proceeding anyway", zap.String("namespace", c.Namespace), zap.Error(err))
+		return nil
+	}
+	return fmt.Errorf("exec preflight failed: %w", err)
+}
@@ -0,0 +1,99 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// ErrSudoPasswordRequired is returned when a command escalated with the
+// Privilege option failed because sudo or su wanted a password.
+var ErrSudoPasswordRequired = fmt.Errorf("privilege escalation requires a password")
+
+// PrivilegeMode selects how commands are escalated in containers where the
+// exec user is not the privileged user.
+type PrivilegeMode int
+
+const (
+	// PrivilegeNone runs commands as the exec user.
+	PrivilegeNone PrivilegeMode = iota
+	// PrivilegeSudo runs commands with "sudo -n --". -n makes sudo fail
+	// instead of prompting when it is not passwordless.
+	PrivilegeSudo
+	// PrivilegeSu runs commands with "su -c", as root.
+	PrivilegeSu
+)
+
+func (m PrivilegeMode) String() string {
+	switch m {
+	case PrivilegeNone:
+		return "none"
+	case PrivilegeSudo:
+		return "sudo"
+	case PrivilegeSu:
+		return "su"
+	}
+	return fmt.Sprintf("PrivilegeMode(%d)", int(m))
+}
+
+// wrap returns command escalated as selected by m.
+func (m PrivilegeMode) wrap(command []string) []string {
+	switch m {
+	case PrivilegeSudo:
+		return append([]string{"sudo", "-n", "--"}, command...)
+	case PrivilegeSu:
+		return []string{"su", "-c", shellJoin(command)}
+	}
+	return command
+}
+
+// passwordPrompts are what sudo and su print when they'd need a password.
+var passwordPrompts = []string{
+	"a password is required",
+	"Password:",
+	"must be run from a terminal",
+}
+
+// passwordPrompted reports whether stderr shows sudo or su asking for a
+// password.
+func passwordPrompted(stderr []byte) bool {
+	for _, prompt := range passwordPrompts {
+		if bytes.Contains(stderr, []byte(prompt)) {
+			return true
+		}
+	}
+	return false
+}
+
+// execEscalated runs opts through exec with its command escalated as the
+// Privilege option asks. Unless the exec uses a TTY, stderr is watched for a
+// password prompt, so a failure because of one is reported as
+// ErrSudoPasswordRequired.
+func (c *Client) execEscalated(ctx context.Context, opts execOptions) error {
+	original := opts.command
+	opts.command = c.Privilege.wrap(opts.command)
+	opts.escalated = true
+
+	log.Info("escalating exec privileges", zap.String("mode", c.Privilege.String()), zap.String("command", strings.Join(original, " ")), zap.String("namespace", c.Namespace), zap.String("container", c.ContainerName))
+
+	var prompt *limitedBuffer
+	if !opts.tty {
+		prompt = &limitedBuffer{limit: 4096}
+		if opts.stderr != nil {
+			opts.stderr = io.MultiWriter(opts.stderr, prompt)
+		} else {
+			opts.stderr = prompt
+		}
+	}
+
+	err := c.exec(ctx, opts)
+	if err != nil && prompt != nil && passwordPrompted(prompt.Bytes()) {
+		return fmt.Errorf("%w: %s needs a password to run %q", ErrSudoPasswordRequired, c.Privilege, strings.Join(original, " "))
+	}
+	return err
+}
@@ -0,0 +1,194 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// processProbeTimeout bounds each process lookup or signal exec.
+const processProbeTimeout = 10 * time.Second
+
+// pgrepScript runs pgrep -f with the pattern read from stdin. Passing the
+// pattern on the command line would make the processes wrapping pgrep match
+// it too, such as sudo or su under the Privilege option, while pgrep only
+// leaves itself out.
+const pgrepScript = `IFS= read -r pattern; exec pgrep -f -- "$pattern"`
+
+// findProcesses returns the PIDs of processes in the target container whose
+// command line matches pattern, as reported by pgrep -f.
+func (c *Client) findProcesses(ctx context.Context, pattern string) ([]int, error) {
+	if strings.Contains(pattern, "\n") {
+		return nil, fmt.Errorf("process pattern %q contains a newline", pattern)
+	}
+
+	var stdoutBuf, stderrBuf bytes.Buffer
+	err := c.exec(ctx, execOptions{
+		command: shellCommand(pgrepScript),
+		stdin:   strings.NewReader(pattern + "\n"),
+		stdout:  &stdoutBuf,
+		stderr:  &stderrBuf,
+		timeout: processProbeTimeout,
+	})
+	stdout, stderr := stdoutBuf.String(), stderrBuf.String()
+	if err != nil {
+		// pgrep exits 1 when nothing matches.
+		if code, ok := exitCode(err); ok && code == 1 {
+			return nil, nil
+		}
+		return nil, withStderr(err, stderr)
+	}
+
+	var pids []int
+	for _, field := range strings.Fields(stdout) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected pgrep output %q", stdout)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// TerminateRemote sends signal to the processes in the target container whose
+// command line matches pattern, e.g. to stop a command started earlier
+// without restarting the container. PID 1 is never signaled, since that would
+// take the whole container down; it is an error if nothing else matches.
+func (c *Client) TerminateRemote(ctx context.Context, pattern string, signal syscall.Signal) error {
+	if strings.TrimSpace(pattern) == "" {
+		return fmt.Errorf("empty process pattern")
+	}
+
+	pids, err := c.findProcesses(ctx, pattern)
+	if err != nil {
+		return fmt.Errorf("failed to find processes matching %q: %w", pattern, err)
+	}
+
+	var targets []string
+	for _, pid := range pids {
+		if pid != 1 {
+			targets = append(targets, strconv.Itoa(pid))
+		}
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("no process other than PID 1 matches %q", pattern)
+	}
+
+	script := fmt.Sprintf("kill -%d %s", int(signal), strings.Join(targets, " "))
+	_, stderr, err := c.capture(ctx, shellCommand(script), processProbeTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to signal processes matching %q: %w", pattern, withStderr(err, stderr))
+	}
+
+	log.Info("signaled remote processes", zap.String("pattern", pattern), zap.String("signal", signal.String()), zap.Int("count", len(targets)), zap.Strings("pids", targets))
+	return nil
+}
+
+// psScript lists all processes as "PID ARGS" lines after a header, preceded by
+// its own PID so the caller can leave the ps process out. Not every ps
+// supports -e; those that don't, like busybox, list all processes anyway.
+const psScript = `echo $$; if ps -e >/dev/null 2>&1; then exec ps -eo pid,args; else exec ps -o pid,args; fi`
+
+// findProcessesPS is findProcesses for containers without pgrep: it matches
+// pattern against the command lines reported by ps.
+func (c *Client) findProcessesPS(ctx context.Context, pattern string) ([]int, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid process pattern: %w", err)
+	}
+
+	stdout, stderr, err := c.capture(ctx, shellCommand(psScript), processProbeTimeout)
+	if err != nil {
+		return nil, withStderr(err, stderr)
+	}
+
+	lines := strings.Split(strings.TrimSpace(stdout), "\n")
+	if len(lines) < 2 {
+		return nil, fmt.Errorf("unexpected ps output %q", stdout)
+	}
+	self := strings.TrimSpace(lines[0])
+
+	var pids []int
+	for _, line := range lines[2:] {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if len(fields) < 2 || fields[0] == self {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("unexpected ps output line %q", line)
+		}
+		if re.MatchString(strings.TrimSpace(fields[1])) {
+			pids = append(pids, pid)
+		}
+	}
+	return pids, nil
+}
+
+// ProcessWaitTimeoutError is returned by WaitForProcess when its context is
+// done before a matching process showed up.
+type ProcessWaitTimeoutError struct {
+	Pattern string
+	Err     error
+}
+
+func (e *ProcessWaitTimeoutError) Error() string {
+	return fmt.Sprintf("timed out waiting for a process matching %q: %v", e.Pattern, e.Err)
+}
+
+func (e *ProcessWaitTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// WaitForProcess polls the target container every interval until a process
+// whose command line matches pattern is running, e.g. to wait for a daemon to
+// start. It uses pgrep -f, or parses ps output where pgrep is missing. When
+// ctx is done first a *ProcessWaitTimeoutError is returned.
+func (c *Client) WaitForProcess(ctx context.Context, pattern string, interval time.Duration) error {
+	if strings.TrimSpace(pattern) == "" {
+		return fmt.Errorf("empty process pattern")
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	find := c.findProcesses
+	if ok, err := c.HasBinary(ctx, "pgrep"); err != nil {
+		return fmt.Errorf("failed to check for pgrep: %w", err)
+	} else if !ok {
+		ok, err := c.HasBinary(ctx, "ps")
+		if err != nil {
+			return fmt.Errorf("failed to check for ps: %w", err)
+		}
+		if !ok {
+			return fmt.Errorf("neither pgrep nor ps is available in container %s", c.ContainerName)
+		}
+		find = c.findProcessesPS
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		pids, err := find(ctx, pattern)
+		if err != nil && ctx.Err() == nil {
+			return fmt.Errorf("failed to find processes matching %q: %w", pattern, err)
+		}
+		if len(pids) > 0 {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return &ProcessWaitTimeoutError{Pattern: pattern, Err: ctx.Err()}
+		}
+	}
+}
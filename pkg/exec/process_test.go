@@ -0,0 +1,65 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeSudo puts a sudo on PATH that, like the real one, stays around as the
+// parent of the command it runs.
+func fakeSudo(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	script := "#!/bin/sh\n[ \"$1\" = -n ] && shift\n[ \"$1\" = -- ] && shift\n\"$@\"\n"
+	if err := os.WriteFile(filepath.Join(dir, "sudo"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestFindProcessesUnderSudo(t *testing.T) {
+	if _, err := exec.LookPath("pgrep"); err != nil {
+		t.Skip("pgrep is not available")
+	}
+	fakeSudo(t)
+	c, _ := newTestClient(t, ClientOpt{Privilege: PrivilegeSudo})
+
+	const pattern = "sleep 987.654321"
+	target := exec.Command("sleep", "987.654321")
+	if err := target.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- target.Wait() }()
+	t.Cleanup(func() { target.Process.Kill() })
+
+	pids, err := c.findProcesses(context.Background(), pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pids) != 1 || pids[0] != target.Process.Pid {
+		t.Fatalf("findProcesses = %v, want only the target %d", pids, target.Process.Pid)
+	}
+
+	if err := c.TerminateRemote(context.Background(), pattern, syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-exited:
+	case <-time.After(10 * time.Second):
+		t.Fatal("TerminateRemote didn't signal the target process")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	var timeout *ProcessWaitTimeoutError
+	if err := c.WaitForProcess(ctx, pattern, 50*time.Millisecond); !errors.As(err, &timeout) {
+		t.Errorf("WaitForProcess after the target exited = %v, want a timeout", err)
+	}
+}
@@ -0,0 +1,231 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// ErrPromptLoop is returned by ExecPodWithPrompts when the same prompt kept
+// coming back after being answered.
+var ErrPromptLoop = fmt.Errorf("prompt repeated too often")
+
+// defaultPromptPattern matches the usual confirmation prompts, e.g.
+// "Continue? [y/N] " or "rm: remove regular file 'x'? ".
+var defaultPromptPattern = regexp.MustCompile(`(\?|\[[yY]/[nN]\]|\([yY]/[nN]\)|:)\s*$`)
+
+// defaultPromptRepeats is how often a prompt may be answered before
+// ExecPodWithPrompts gives up, unless PromptOptions says otherwise.
+const defaultPromptRepeats = 3
+
+// defaultPromptQuietPeriod is how long output must pause after a prompt
+// before it is answered, unless PromptOptions says otherwise.
+const defaultPromptQuietPeriod = 200 * time.Millisecond
+
+// PromptResponder answers a prompt. Returning false declines to answer, which
+// closes the command's stdin.
+type PromptResponder func(promptText string) (response string, ok bool)
+
+// PromptOptions configure ExecPodWithPrompts.
+type PromptOptions struct {
+	// Responder answers the prompts. It is required.
+	Responder PromptResponder
+	// Pattern matches the pending, not yet newline-terminated output that
+	// counts as a prompt. It defaults to output ending in "?", ":", "[y/N]"
+	// or "(y/n)".
+	Pattern *regexp.Regexp
+	// MaxRepeats is how often the same prompt may be answered before the
+	// command is aborted with ErrPromptLoop. It defaults to 3.
+	MaxRepeats int
+	// QuietPeriod is how long the output must stay idle after matching
+	// output before it is taken as a prompt, so a line that merely arrives
+	// in pieces, e.g. "key:" and then " value", isn't answered. It defaults
+	// to 200ms.
+	QuietPeriod time.Duration
+}
+
+// promptWatcher passes output through to w and looks for a prompt at the end
+// of each write. Matching output is only answered once no further write came
+// for the prompter's quiet period. A write ending in the middle of a
+// multi-byte character is not checked, so prompts reach the responder as
+// valid UTF-8.
+type promptWatcher struct {
+	w io.Writer
+	p *prompter
+
+	mu      sync.Mutex
+	partial []byte
+	// writes counts the writes, so a pending answer can tell whether more
+	// output came after it was scheduled.
+	writes uint64
+	timer  *time.Timer
+}
+
+func (pw *promptWatcher) Write(p []byte) (int, error) {
+	if pw.w != nil {
+		if _, err := pw.w.Write(p); err != nil {
+			return 0, err
+		}
+	}
+
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	pw.writes++
+	if pw.timer != nil {
+		pw.timer.Stop()
+		pw.timer = nil
+	}
+	data := append(pw.partial, p...)
+	if i := bytes.LastIndexByte(data, '\n'); i >= 0 {
+		data = data[i+1:]
+	}
+	pw.partial = append(pw.partial[:0], data...)
+	if len(pw.partial) > 0 && incompleteUTF8(pw.partial) == 0 && pw.p.pattern.Match(pw.partial) {
+		writes, text := pw.writes, string(pw.partial)
+		pw.timer = time.AfterFunc(pw.p.opts.QuietPeriod, func() { pw.answer(writes, text) })
+	}
+	return len(p), nil
+}
+
+// answer hands text to the prompter unless more output came after the write
+// that ended with it.
+func (pw *promptWatcher) answer(writes uint64, text string) {
+	pw.mu.Lock()
+	if pw.writes != writes {
+		pw.mu.Unlock()
+		return
+	}
+	pw.partial = pw.partial[:0]
+	pw.timer = nil
+	pw.mu.Unlock()
+	pw.p.prompted(text)
+}
+
+// stop cancels a pending answer.
+func (pw *promptWatcher) stop() {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if pw.timer != nil {
+		pw.timer.Stop()
+		pw.timer = nil
+	}
+}
+
+// prompter answers the prompts seen by its watchers on the command's stdin.
+type prompter struct {
+	opts    PromptOptions
+	pattern *regexp.Regexp
+	cancel  context.CancelFunc
+	answers chan string
+
+	mu      sync.Mutex
+	last    string
+	repeats int
+	closed  bool
+	err     error
+}
+
+func (p *prompter) prompted(text string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+
+	if text == p.last {
+		p.repeats++
+	} else {
+		p.last, p.repeats = text, 1
+	}
+	if p.repeats > p.opts.MaxRepeats {
+		p.err = fmt.Errorf("%w: %q", ErrPromptLoop, text)
+		p.close()
+		p.cancel()
+		return
+	}
+
+	response, ok := p.opts.Responder(text)
+	if !ok {
+		p.close()
+		return
+	}
+	// Never block the output path: a command that stopped reading its
+	// stdin would stall it for good.
+	select {
+	case p.answers <- response + "\n":
+	default:
+		p.err = fmt.Errorf("command is not reading the answers to its prompts")
+		p.close()
+		p.cancel()
+	}
+}
+
+// close ends the command's stdin. p.mu must be held.
+func (p *prompter) close() {
+	if !p.closed {
+		p.closed = true
+		close(p.answers)
+	}
+}
+
+// ExecPodWithPrompts runs command and answers the prompts it prints on stdout
+// or stderr through opts.Responder, writing the answers to its stdin, so
+// semi-interactive commands such as "rm -i" can run unattended. Output is
+// passed on to stdout and stderr as it arrives.
+func (c *Client) ExecPodWithPrompts(command []string, opts PromptOptions, stdout, stderr io.Writer, timeout time.Duration) error {
+	if opts.Responder == nil {
+		return fmt.Errorf("no prompt responder")
+	}
+	if opts.MaxRepeats <= 0 {
+		opts.MaxRepeats = defaultPromptRepeats
+	}
+	if opts.QuietPeriod <= 0 {
+		opts.QuietPeriod = defaultPromptQuietPeriod
+	}
+	pattern := opts.Pattern
+	if pattern == nil {
+		pattern = defaultPromptPattern
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p := &prompter{opts: opts, pattern: pattern, cancel: cancel, answers: make(chan string, 16)}
+	stdinR, stdinW := io.Pipe()
+	go func() {
+		for answer := range p.answers {
+			if _, err := io.WriteString(stdinW, answer); err != nil {
+				break
+			}
+		}
+		stdinW.Close()
+	}()
+
+	stdoutWatcher := &promptWatcher{w: stdout, p: p}
+	stderrWatcher := &promptWatcher{w: stderr, p: p}
+	err := c.exec(ctx, execOptions{
+		command: command,
+		explain: true,
+		stdin:   stdinR,
+		stdout:  stdoutWatcher,
+		stderr:  stderrWatcher,
+		timeout: timeout,
+	})
+	stdoutWatcher.stop()
+	stderrWatcher.stop()
+
+	p.mu.Lock()
+	p.close()
+	loopErr := p.err
+	p.mu.Unlock()
+	stdinR.Close()
+
+	if loopErr != nil {
+		return loopErr
+	}
+	return err
+}
@@ -0,0 +1,212 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PodTarget describes the pod an exec should land in. Fields are consulted in
+// the order Name, IP, Workload and then LabelSelector/FieldSelector.
+type PodTarget struct {
+	Name string
+	IP   string
+	// Workload is a "kind/name" reference such as "deployment/web". The
+	// supported kinds are deployment, statefulset, daemonset, replicaset and
+	// job.
+	Workload string
+
+	LabelSelector string
+	FieldSelector string
+}
+
+// IsZero reports whether no field of the target is set.
+func (t PodTarget) IsZero() bool {
+	return t == PodTarget{}
+}
+
+// selectorBased reports whether the target selects pods by workload or
+// selector, so another pod may stand in for the one it resolved to before.
+func (t PodTarget) selectorBased() bool {
+	return t.Workload != "" || t.LabelSelector != "" || t.FieldSelector != ""
+}
+
+// PodResolver turns a PodTarget into the name of a concrete pod.
+type PodResolver interface {
+	ResolvePod(ctx context.Context, namespace string, target PodTarget) (string, error)
+}
+
+// DefaultPodResolver resolves targets with the Kubernetes API. When several
+// pods match, running pods that are not being deleted are preferred, ready
+// ones first and then the oldest.
+type DefaultPodResolver struct {
+	Client kubernetes.Interface
+}
+
+// NewDefaultPodResolver returns a DefaultPodResolver backed by client.
+func NewDefaultPodResolver(client kubernetes.Interface) *DefaultPodResolver {
+	return &DefaultPodResolver{Client: client}
+}
+
+// ResolvePod implements PodResolver.
+func (r *DefaultPodResolver) ResolvePod(ctx context.Context, namespace string, target PodTarget) (string, error) {
+	switch {
+	case target.Name != "":
+		return target.Name, nil
+	case target.IP != "":
+		return r.pick(ctx, namespace, metav1.ListOptions{
+			FieldSelector: fields.OneTermEqualSelector("status.podIP", target.IP).String(),
+		})
+	case target.Workload != "":
+		selector, err := r.workloadSelector(ctx, namespace, target.Workload)
+		if err != nil {
+			return "", err
+		}
+		return r.pick(ctx, namespace, metav1.ListOptions{LabelSelector: selector})
+	case target.LabelSelector != "" || target.FieldSelector != "":
+		return r.pick(ctx, namespace, metav1.ListOptions{
+			LabelSelector: target.LabelSelector,
+			FieldSelector: target.FieldSelector,
+		})
+	}
+	return "", fmt.Errorf("empty pod target")
+}
+
+// workloadSelector returns the pod label selector of a "kind/name" workload.
+func (r *DefaultPodResolver) workloadSelector(ctx context.Context, namespace, workload string) (string, error) {
+	parts := strings.SplitN(workload, "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", fmt.Errorf("invalid workload %q, expected kind/name", workload)
+	}
+	kind, name := strings.ToLower(parts[0]), parts[1]
+
+	var (
+		selector *metav1.LabelSelector
+		err      error
+	)
+	switch kind {
+	case "deployment", "deploy":
+		obj, getErr := r.Client.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err = getErr; err == nil {
+			selector = obj.Spec.Selector
+		}
+	case "statefulset", "sts":
+		obj, getErr := r.Client.AppsV1().StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err = getErr; err == nil {
+			selector = obj.Spec.Selector
+		}
+	case "daemonset", "ds":
+		obj, getErr := r.Client.AppsV1().DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err = getErr; err == nil {
+			selector = obj.Spec.Selector
+		}
+	case "replicaset", "rs":
+		obj, getErr := r.Client.AppsV1().ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err = getErr; err == nil {
+			selector = obj.Spec.Selector
+		}
+	case "job":
+		obj, getErr := r.Client.BatchV1().Jobs(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err = getErr; err == nil {
+			selector = obj.Spec.Selector
+		}
+	default:
+		return "", fmt.Errorf("unsupported workload kind %q", parts[0])
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s: %w", workload, err)
+	}
+	if selector == nil {
+		return "", fmt.Errorf("%s has no pod selector", workload)
+	}
+
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return "", fmt.Errorf("invalid selector on %s: %w", workload, err)
+	}
+	return s.String(), nil
+}
+
+// pick lists the pods matching opts and returns the best candidate.
+func (r *DefaultPodResolver) pick(ctx context.Context, namespace string, opts metav1.ListOptions) (string, error) {
+	pods, err := r.Client.CoreV1().Pods(namespace).List(ctx, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	var candidates []corev1.Pod
+	for _, pod := range pods.Items {
+		if pod.Status.Phase == corev1.PodRunning && pod.DeletionTimestamp == nil {
+			candidates = append(candidates, pod)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("no running pod matches label selector %q, field selector %q in namespace %s", opts.LabelSelector, opts.FieldSelector, namespace)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ri, rj := podReady(&candidates[i]), podReady(&candidates[j])
+		if ri != rj {
+			return ri
+		}
+		return candidates[i].CreationTimestamp.Before(&candidates[j].CreationTimestamp)
+	})
+	return candidates[0].Name, nil
+}
+
+// podReady reports whether the pod's Ready condition is true.
+func podReady(pod *corev1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// podName returns the name of the pod to exec into. A fixed PodName is used
+// as is unless a Target or Resolver is configured, in which case the resolved
+// name is remembered for the lifetime of the client.
+func (c *Client) podName(ctx context.Context) (string, error) {
+	if c.Target.IsZero() && c.Resolver == nil {
+		return c.PodName, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.resolvedPod != "" {
+		return c.resolvedPod, nil
+	}
+
+	target := c.Target
+	if target.IsZero() {
+		target.Name = c.PodName
+	}
+	resolver := c.Resolver
+	if resolver == nil {
+		resolver = NewDefaultPodResolver(c.Interface)
+	}
+
+	name, err := resolver.ResolvePod(ctx, c.Namespace, target)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve pod: %w", err)
+	}
+	c.resolvedPod = name
+	return name, nil
+}
+
+// forgetPod drops the remembered resolution if it is still name, so the next
+// podName call resolves the target again.
+func (c *Client) forgetPod(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.resolvedPod == name {
+		c.resolvedPod = ""
+	}
+}
@@ -0,0 +1,173 @@
+package exec
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+const (
+	defaultRetryBackoff = time.Second
+	defaultRetryMaxWait = 30 * time.Second
+)
+
+// RetryPolicy controls retrying execs whose stream could not be set up. Only
+// failures of the upgrade request are retried: at that point nothing has been
+// read from stdin yet, so the command can safely be sent again.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one.
+	// Values below 2 disable retries.
+	MaxAttempts int
+	// Backoff is the wait before the first retry and doubles for each one
+	// after it. It defaults to one second.
+	Backoff time.Duration
+	// MaxWait caps the total time spent waiting between attempts. It
+	// defaults to 30 seconds.
+	MaxWait time.Duration
+	// RetryableStatusCodes are the HTTP status codes of the upgrade response
+	// that are retried, e.g. 429 and 503. A Retry-After header, in seconds
+	// or as an HTTP date, takes precedence over Backoff.
+	RetryableStatusCodes []int
+	// RediscoverPod resolves the client's Target again before each retry, so
+	// a retry lands on a current replica when the pod was replaced, e.g.
+	// during a rollout. Unlike plain retries, which always go to the same
+	// pod, consecutive attempts may then run on different pods. It only
+	// applies to targets selecting pods by Workload, LabelSelector or
+	// FieldSelector; fixed pod names are retried as is. List
+	// http.StatusNotFound in RetryableStatusCodes to also retry execs
+	// against a pod that is already gone.
+	RediscoverPod bool
+	// RetryStderrPattern also retries commands that exited with a non-zero
+	// status when their stderr matches it, e.g. "starting up, try again", for
+	// transient failures the application reports itself. Only the last 64KiB
+	// of stderr are matched, and only for execs without a TTY, whose stderr
+	// is separate. Every attempt writes to the same stdout and stderr, so
+	// they see the output of the failed attempts too. The command runs again
+	// from the start, so its stdin must be replayed: a stdin implementing
+	// io.Seeker is rewound to where the first attempt started reading, any
+	// other stdin disables these retries.
+	RetryStderrPattern *regexp.Regexp
+}
+
+// stderrRetryTail is how much of the end of stderr RetryStderrPattern sees.
+const stderrRetryTail = 64 << 10
+
+// upgradeError is returned when the API server refuses to upgrade an exec
+// request. It keeps the parts of the response the retry policy looks at.
+type upgradeError struct {
+	statusCode int
+	retryAfter string
+	err        error
+}
+
+func (e *upgradeError) Error() string { return e.err.Error() }
+func (e *upgradeError) Unwrap() error { return e.err }
+
+// setupStatusCode returns the HTTP status an exec setup failure carries.
+func setupStatusCode(err error) (int, bool) {
+	var upgradeErr *upgradeError
+	if errors.As(err, &upgradeErr) {
+		return upgradeErr.statusCode, true
+	}
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		return int(status.Status().Code), true
+	}
+	return 0, false
+}
+
+// parseRetryAfter parses a Retry-After header value given either in seconds
+// or as an HTTP date.
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// retryDelay decides whether the failed attempt should be retried and how
+// long to wait first, given the time already spent waiting.
+func (p RetryPolicy) retryDelay(err error, attempt int, waited time.Duration) (time.Duration, bool) {
+	if attempt >= p.MaxAttempts {
+		return 0, false
+	}
+	code, ok := setupStatusCode(err)
+	if !ok || !p.retryable(code) {
+		return 0, false
+	}
+	return p.capDelay(p.delay(err, attempt), waited)
+}
+
+// stderrRetryDelay is retryDelay for commands that exited with a non-zero
+// status, which RetryStderrPattern decides on.
+func (p RetryPolicy) stderrRetryDelay(err error, stderr []byte, attempt int, waited time.Duration) (time.Duration, bool) {
+	if p.RetryStderrPattern == nil || attempt >= p.MaxAttempts {
+		return 0, false
+	}
+	if _, ok := exitCode(err); !ok || !p.RetryStderrPattern.Match(stderr) {
+		return 0, false
+	}
+	return p.capDelay(p.delay(err, attempt), waited)
+}
+
+func (p RetryPolicy) retryable(code int) bool {
+	for _, c := range p.RetryableStatusCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// delay returns the wait before retry number attempt, honoring Retry-After.
+func (p RetryPolicy) delay(err error, attempt int) time.Duration {
+	var upgradeErr *upgradeError
+	if errors.As(err, &upgradeErr) {
+		if d, ok := parseRetryAfter(upgradeErr.retryAfter); ok {
+			return d
+		}
+	}
+	if seconds, ok := apierrors.SuggestsClientDelay(err); ok && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	backoff := p.Backoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+	shift := attempt - 1
+	if shift > 16 {
+		shift = 16
+	}
+	return backoff << shift
+}
+
+// capDelay trims delay to what is left of MaxWait; nothing left means no
+// more retries.
+func (p RetryPolicy) capDelay(delay, waited time.Duration) (time.Duration, bool) {
+	maxWait := p.MaxWait
+	if maxWait <= 0 {
+		maxWait = defaultRetryMaxWait
+	}
+	left := maxWait - waited
+	if left <= 0 {
+		return 0, false
+	}
+	if delay > left {
+		delay = left
+	}
+	return delay, true
+}
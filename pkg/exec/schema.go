@@ -0,0 +1,90 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// schemaURL is the location the schema given to ExecPodValidatedJSON is
+// registered under; it only shows up in validation messages.
+const schemaURL = "exec-output.json"
+
+// SchemaValidationError is returned by ExecPodValidatedJSON when the
+// command's output does not match the schema.
+type SchemaValidationError struct {
+	// Failures lists each violation as "<instance location>: <message>", or
+	// the parse error if the output is not JSON at all.
+	Failures []string
+	// Stderr is what the command wrote to stderr.
+	Stderr string
+}
+
+func (e *SchemaValidationError) Error() string {
+	msg := "output does not match schema: " + strings.Join(e.Failures, "; ")
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		msg += ", stderr: " + stderr
+	}
+	return msg
+}
+
+// schemaFailures flattens a validation error into its leaf causes.
+func schemaFailures(ve *jsonschema.ValidationError) []string {
+	if len(ve.Causes) == 0 {
+		location := ve.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		return []string{location + ": " + ve.Message}
+	}
+	var failures []string
+	for _, cause := range ve.Causes {
+		failures = append(failures, schemaFailures(cause)...)
+	}
+	return failures
+}
+
+// ExecPodValidatedJSON runs command and returns its stdout only if it is JSON
+// matching schema, a JSON Schema document, so scripts that drift from their
+// output contract are caught where they are called. A mismatch is reported as
+// a *SchemaValidationError listing every violation.
+func (c *Client) ExecPodValidatedJSON(command []string, schema []byte, timeout time.Duration) ([]byte, error) {
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(schemaURL, bytes.NewReader(schema)); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	compiled, err := compiler.Compile(schemaURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+
+	stdout, stderr, err := c.captureExplained(context.Background(), command, timeout)
+	if err != nil {
+		return nil, withStderr(err, stderr)
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(stdout))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, &SchemaValidationError{Failures: []string{"invalid JSON: " + err.Error()}, Stderr: stderr}
+	}
+	if decoder.More() {
+		return nil, &SchemaValidationError{Failures: []string{"invalid JSON: unexpected data after the top-level value"}, Stderr: stderr}
+	}
+
+	if err := compiled.Validate(value); err != nil {
+		var ve *jsonschema.ValidationError
+		if !errors.As(err, &ve) {
+			return nil, err
+		}
+		return nil, &SchemaValidationError{Failures: schemaFailures(ve), Stderr: stderr}
+	}
+	return []byte(stdout), nil
+}
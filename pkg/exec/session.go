@@ -0,0 +1,382 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrStdinClosed is returned when writing to a Session whose stdin has been
+// closed.
+var ErrStdinClosed = fmt.Errorf("session stdin is closed")
+
+// ErrDetached is returned by Session.Wait and Session.Write after the
+// session was detached with its detach keys.
+var ErrDetached = fmt.Errorf("detached from session")
+
+// errSessionDone unblocks stdin writers once the stream has finished.
+var errSessionDone = fmt.Errorf("session has finished")
+
+// SessionOptions configures a Session.
+type SessionOptions struct {
+	Command []string
+	// Stdin, if set, is copied into the session until EOF, after which the
+	// session's stdin is closed. Callers can also write with Session.Write.
+	Stdin   io.Reader
+	Stdout  io.Writer
+	Stderr  io.Writer
+	TTY     bool
+	Timeout time.Duration
+	// DetachKeys is a comma separated key sequence such as "ctrl-p,ctrl-q".
+	// When it shows up in stdin the client closes the stream without sending
+	// the sequence, leaving the remote process to the container. Keys are
+	// "ctrl-" followed by a letter or one of @[\]^_, or a single character.
+	DetachKeys string
+	// Expect keeps stdout, in addition to passing it to Stdout, for
+	// Session.ExpectPrompt.
+	Expect bool
+}
+
+// Session is an exec stream running in the background whose stdin is fed by
+// the caller.
+type Session struct {
+	mu     sync.Mutex
+	stdin  *io.PipeWriter
+	closed bool
+	// drained is closed once the stream has read EOF from stdin, i.e. every
+	// byte written before CloseStdin has been handed to the remote.
+	drained chan struct{}
+	// detach scans stdin for the detach keys; nil when none are set.
+	detach   *detachScanner
+	detached bool
+	// expect keeps stdout for ExpectPrompt; nil unless SessionOptions.Expect
+	// is set.
+	expect *expectBuffer
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// sessionStdin is the stdin the exec stream reads from. It reports when the
+// stream has consumed everything up to EOF.
+type sessionStdin struct {
+	r       *io.PipeReader
+	drained chan struct{}
+	once    sync.Once
+}
+
+func (s *sessionStdin) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if err == io.EOF {
+		s.once.Do(func() { close(s.drained) })
+	}
+	return n, err
+}
+
+// StartSession starts opts.Command in the target container and returns as
+// soon as the stream is set up in the background. Errors from the stream are
+// reported by Wait.
+func (c *Client) StartSession(ctx context.Context, opts SessionOptions) (*Session, error) {
+	if len(opts.Command) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+
+	var detach *detachScanner
+	if opts.DetachKeys != "" {
+		keys, err := parseDetachKeys(opts.DetachKeys)
+		if err != nil {
+			return nil, err
+		}
+		detach = newDetachScanner(keys)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	s := &Session{
+		stdin:   pw,
+		drained: make(chan struct{}),
+		detach:  detach,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+
+	stdout := opts.Stdout
+	if opts.Expect {
+		s.expect = &expectBuffer{notify: make(chan struct{})}
+		if stdout != nil {
+			stdout = io.MultiWriter(stdout, s.expect)
+		} else {
+			stdout = s.expect
+		}
+	}
+
+	go func() {
+		defer close(s.done)
+		defer cancel()
+
+		err := c.exec(ctx, execOptions{
+			command: opts.Command,
+			explain: true,
+			stdin:   &sessionStdin{r: pr, drained: s.drained},
+			stdout:  stdout,
+			stderr:  opts.Stderr,
+			tty:     opts.TTY,
+			timeout: opts.Timeout,
+		})
+		pr.CloseWithError(errSessionDone)
+
+		s.mu.Lock()
+		if s.detached {
+			err = ErrDetached
+		}
+		s.err = err
+		s.mu.Unlock()
+	}()
+
+	if opts.Stdin != nil {
+		go func() {
+			if _, err := io.Copy(s, opts.Stdin); err == nil {
+				s.CloseStdin()
+			}
+		}()
+	}
+
+	return s, nil
+}
+
+// Write sends p to the remote command's stdin. It returns once the stream has
+// taken all of p. If p completes the detach keys, the session is detached and
+// ErrDetached is returned.
+func (s *Session) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.detached {
+		return 0, ErrDetached
+	}
+	if s.closed {
+		return 0, ErrStdinClosed
+	}
+	if s.detach == nil {
+		return s.stdin.Write(p)
+	}
+
+	out, detached := s.detach.scan(p)
+	if len(out) > 0 {
+		if _, err := s.stdin.Write(out); err != nil {
+			return 0, err
+		}
+	}
+	if detached {
+		s.detached = true
+		s.cancel()
+		return len(p), ErrDetached
+	}
+	return len(p), nil
+}
+
+// SendLine writes line and a newline to the remote command's stdin, e.g. a
+// statement for a REPL. Like Write, it returns once the stream has taken it.
+func (s *Session) SendLine(line string) error {
+	_, err := s.Write([]byte(line + "\n"))
+	return err
+}
+
+// ExpectPrompt waits until stdout ends with a match of pattern, i.e. the
+// remote command printed its prompt and waits for input, and returns the
+// output received before the prompt. Output is consumed up to and including
+// the prompt, so the next call only sees what comes after it. A match that
+// more output follows, like a prompt string echoed in the middle of a
+// command's output, doesn't count. The session must have been started with
+// SessionOptions.Expect.
+func (s *Session) ExpectPrompt(pattern *regexp.Regexp, timeout time.Duration) (string, error) {
+	if s.expect == nil {
+		return "", fmt.Errorf("session was not started with Expect")
+	}
+
+	var timer <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		timer = t.C
+	}
+
+	for {
+		output, ok, notify := s.expect.take(pattern)
+		if ok {
+			return output, nil
+		}
+		select {
+		case <-notify:
+		case <-s.done:
+			if output, ok, _ := s.expect.take(pattern); ok {
+				return output, nil
+			}
+			return "", fmt.Errorf("session ended before prompt %q appeared", pattern)
+		case <-timer:
+			return "", fmt.Errorf("timed out after %s waiting for prompt %q", timeout, pattern)
+		}
+	}
+}
+
+// expectBuffer collects a session's stdout for ExpectPrompt.
+type expectBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+	// notify is closed and replaced on every write.
+	notify chan struct{}
+}
+
+func (b *expectBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	close(b.notify)
+	b.notify = make(chan struct{})
+	return len(p), nil
+}
+
+// take looks for pattern at the very end of the buffered output. On a match
+// it returns and consumes the output before the prompt, dropping the prompt
+// itself. Otherwise it returns a channel closed on the next write.
+func (b *expectBuffer) take(pattern *regexp.Regexp) (string, bool, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	matches := pattern.FindAllIndex(b.buf, -1)
+	if len(matches) == 0 || matches[len(matches)-1][1] != len(b.buf) {
+		return "", false, b.notify
+	}
+	last := matches[len(matches)-1]
+	output := string(b.buf[:last[0]])
+	b.buf = b.buf[:0]
+	return output, true, nil
+}
+
+// CloseStdin signals EOF to the remote command. Unlike closing a plain pipe,
+// it returns only after the stream has consumed every byte written before it,
+// so nothing buffered is lost, or once the session has finished. Bytes held
+// back as a possible start of the detach keys are sent first.
+func (s *Session) CloseStdin() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	if s.detach != nil && !s.detached {
+		if pending := s.detach.flush(); len(pending) > 0 {
+			s.stdin.Write(pending)
+		}
+	}
+	s.stdin.Close()
+	s.mu.Unlock()
+
+	select {
+	case <-s.drained:
+	case <-s.done:
+	}
+	return nil
+}
+
+// Wait blocks until the remote command exits and returns the stream's error.
+func (s *Session) Wait() error {
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close aborts the session and waits for the stream to be torn down.
+func (s *Session) Close() error {
+	s.cancel()
+	return s.Wait()
+}
+
+// parseDetachKeys turns a spec like "ctrl-p,ctrl-q" into the bytes it stands
+// for.
+func parseDetachKeys(spec string) ([]byte, error) {
+	var keys []byte
+	for _, key := range strings.Split(spec, ",") {
+		key = strings.TrimSpace(key)
+		lower := strings.ToLower(key)
+		switch {
+		case len(key) == 1:
+			keys = append(keys, key[0])
+		case strings.HasPrefix(lower, "ctrl-") && len(lower) == len("ctrl-")+1:
+			ch := lower[len("ctrl-")]
+			switch {
+			case ch >= 'a' && ch <= 'z':
+				keys = append(keys, ch-'a'+1)
+			case strings.IndexByte("@[\\]^_", ch) >= 0:
+				keys = append(keys, ch-'@')
+			default:
+				return nil, fmt.Errorf("invalid detach key %q", key)
+			}
+		default:
+			return nil, fmt.Errorf("invalid detach key %q", key)
+		}
+	}
+	return keys, nil
+}
+
+// detachScanner finds the detach key sequence in a stream of writes. Bytes
+// that might be the start of the sequence are held back until it is either
+// completed or broken. A broken partial match falls back to the longest
+// prefix of the sequence it still ends with, as in Knuth-Morris-Pratt, so
+// sequences like "a,a,b" are found in "aaab".
+type detachScanner struct {
+	keys []byte
+	// fallback[i] is the length of the longest proper prefix of keys[:i+1]
+	// that is also a suffix of it.
+	fallback []int
+	matched  int
+}
+
+func newDetachScanner(keys []byte) *detachScanner {
+	fallback := make([]int, len(keys))
+	for i, k := 1, 0; i < len(keys); i++ {
+		for k > 0 && keys[i] != keys[k] {
+			k = fallback[k-1]
+		}
+		if keys[i] == keys[k] {
+			k++
+		}
+		fallback[i] = k
+	}
+	return &detachScanner{keys: keys, fallback: fallback}
+}
+
+// scan returns the bytes of p to forward and whether the sequence completed.
+func (d *detachScanner) scan(p []byte) (out []byte, detached bool) {
+	for _, b := range p {
+		for d.matched > 0 && b != d.keys[d.matched] {
+			// The held back bytes are keys[:matched]; all but the prefix
+			// they still end with can't be part of a match any more.
+			next := d.fallback[d.matched-1]
+			out = append(out, d.keys[:d.matched-next]...)
+			d.matched = next
+		}
+		if b == d.keys[d.matched] {
+			d.matched++
+			if d.matched == len(d.keys) {
+				d.matched = 0
+				return out, true
+			}
+			continue
+		}
+		out = append(out, b)
+	}
+	return out, false
+}
+
+// flush returns the bytes held back for a partial match, which are no longer
+// going to complete once stdin ends.
+func (d *detachScanner) flush() []byte {
+	out := append([]byte(nil), d.keys[:d.matched]...)
+	d.matched = 0
+	return out
+}
@@ -0,0 +1,121 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSessionCloseStdinDeliversEverything(t *testing.T) {
+	c, _ := newTestClient(t, ClientOpt{})
+
+	const size = 8 << 20
+	payload := bytes.Repeat([]byte("0123456789abcdef"), size/16)
+	for i := 0; i < 5; i++ {
+		var stdout bytes.Buffer
+		s, err := c.StartSession(context.Background(), SessionOptions{
+			Command: []string{"wc", "-c"},
+			Stdout:  &stdout,
+			Timeout: time.Minute,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Write in odd-sized chunks so the last one ends mid-buffer.
+		for rest := payload; len(rest) > 0; {
+			n := 65537
+			if n > len(rest) {
+				n = len(rest)
+			}
+			if _, err := s.Write(rest[:n]); err != nil {
+				t.Fatal(err)
+			}
+			rest = rest[n:]
+		}
+		if err := s.CloseStdin(); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Wait(); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := strconv.Atoi(strings.TrimSpace(stdout.String()))
+		if err != nil {
+			t.Fatalf("unexpected wc output %q", stdout.String())
+		}
+		if got != size {
+			t.Fatalf("run %d: remote counted %d bytes, want %d", i, got, size)
+		}
+	}
+}
+
+func TestDetachScanner(t *testing.T) {
+	tests := []struct {
+		keys     string
+		writes   []string
+		out      string
+		detached bool
+		pending  string
+	}{
+		{"\x10\x11", []string{"ls\x10\x11"}, "ls", true, ""},
+		{"\x10\x11", []string{"ls\x10", "\x11"}, "ls", true, ""},
+		{"\x10\x11", []string{"a\x10b"}, "a\x10b", false, ""},
+		{"\x10\x11", []string{"a\x10\x10\x11"}, "a\x10", true, ""},
+		{"aab", []string{"aaab"}, "a", true, ""},
+		{"aab", []string{"a", "a", "a", "b"}, "a", true, ""},
+		{"abab", []string{"abaxabab"}, "abax", true, ""},
+		{"abab", []string{"ababab"}, "", true, ""},
+		{"abac", []string{"ababac"}, "ab", true, ""},
+		{"\x10\x11", []string{"echo\x10"}, "echo", false, "\x10"},
+		{"aab", []string{"xaa"}, "x", false, "aa"},
+	}
+	for _, tt := range tests {
+		d := newDetachScanner([]byte(tt.keys))
+		var out []byte
+		detached := false
+		for _, w := range tt.writes {
+			o, done := d.scan([]byte(w))
+			out = append(out, o...)
+			if done {
+				detached = true
+				break
+			}
+		}
+		if string(out) != tt.out || detached != tt.detached {
+			t.Errorf("keys %q, writes %q: out %q, detached %v; want %q, %v", tt.keys, tt.writes, out, detached, tt.out, tt.detached)
+		}
+		if pending := string(d.flush()); pending != tt.pending {
+			t.Errorf("keys %q, writes %q: flushed %q, want %q", tt.keys, tt.writes, pending, tt.pending)
+		}
+	}
+}
+
+func TestSessionFlushesPartialDetachKeys(t *testing.T) {
+	c, _ := newTestClient(t, ClientOpt{})
+
+	var stdout bytes.Buffer
+	s, err := c.StartSession(context.Background(), SessionOptions{
+		Command:    []string{"cat"},
+		Stdout:     &stdout,
+		DetachKeys: "ctrl-p,ctrl-q",
+		Timeout:    time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Write([]byte("hello\x10")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CloseStdin(); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if got := stdout.String(); got != "hello\x10" {
+		t.Errorf("remote read %q, want %q", got, "hello\x10")
+	}
+}
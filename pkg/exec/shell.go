@@ -0,0 +1,270 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// binaryCheckTimeout bounds the probes run by HasBinary.
+const binaryCheckTimeout = 10 * time.Second
+
+// shellQuote quotes s so a POSIX shell treats it as a single word.
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	if strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:,+@%", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// shellJoin quotes every argument of command and joins them into a string a
+// POSIX shell splits back into the same arguments.
+func shellJoin(command []string) string {
+	quoted := make([]string, len(command))
+	for i, arg := range command {
+		quoted[i] = shellQuote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// shellCommand wraps script so it is run by sh in the container.
+func shellCommand(script string) []string {
+	return ShellOptions{}.command(script)
+}
+
+// ShellOptions controls how the shell helpers invoke the container's shell.
+type ShellOptions struct {
+	// Shell is the shell to run, "sh" when empty. DetectShell can pick one.
+	Shell string
+	// LoginShell runs the script with "sh -lc" instead of "sh -c" so that
+	// /etc/profile and the user's profile are sourced first, as in an
+	// interactive login. This costs the time of running the profile scripts
+	// on every call, and shells that don't support -l fail to start.
+	LoginShell bool
+}
+
+// command returns the argv that runs script according to o.
+func (o ShellOptions) command(script string) []string {
+	shell := o.Shell
+	if shell == "" {
+		shell = "sh"
+	}
+	if o.LoginShell {
+		return []string{shell, "-lc", script}
+	}
+	return []string{shell, "-c", script}
+}
+
+// ErrNoShell is returned by DetectShell when none of the candidate shells
+// exist, as in distroless images. An ephemeral debug container is usually the
+// way forward then.
+var ErrNoShell = fmt.Errorf("no shell found in container")
+
+// DefaultShells are the shells DetectShell probes when given no candidates,
+// most capable first.
+var DefaultShells = []string{"/bin/bash", "/bin/ash", "/bin/sh"}
+
+// DetectShell returns the first of candidates that exists in the target
+// container, or ErrNoShell. Each candidate is probed by having it run
+// "test -x" on itself, which needs nothing but the shell to be present. Only
+// probes that ran and found the shell missing move on to the next candidate;
+// other failures, such as a denied exec or an unreachable pod, are returned
+// as they are.
+func (c *Client) DetectShell(ctx context.Context, candidates []string) (string, error) {
+	if len(candidates) == 0 {
+		candidates = DefaultShells
+	}
+	for _, shell := range candidates {
+		_, _, err := c.capture(ctx, []string{shell, "-c", "test -x " + shellQuote(shell)}, binaryCheckTimeout)
+		if err == nil {
+			return shell, nil
+		}
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if !shellMissing(err) {
+			return "", err
+		}
+	}
+	return "", ErrNoShell
+}
+
+// shellMissing reports whether a shell probe failed because the shell isn't
+// there: it exited non-zero, usually 126 or 127, or the runtime failed to
+// start it.
+func shellMissing(err error) bool {
+	if code, ok := exitCode(err); ok && code != 0 {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "not found") || strings.Contains(msg, "no such file")
+}
+
+// ExecPodShell runs script through the container's shell as configured by
+// opts.
+func (c *Client) ExecPodShell(script string, opts ShellOptions, stdout, stderr io.Writer, timeout time.Duration) error {
+	return c.ExecPod(opts.command(script), nil, stdout, stderr, false, timeout)
+}
+
+// HasBinary reports whether name resolves to an executable in the target
+// container, using the shell's command -v.
+func (c *Client) HasBinary(ctx context.Context, name string) (bool, error) {
+	_, stderr, err := c.capture(ctx, shellCommand("command -v "+shellQuote(name)), binaryCheckTimeout)
+	if err != nil {
+		if _, ok := exitCode(err); ok {
+			return false, nil
+		}
+		return false, withStderr(err, stderr)
+	}
+	return true, nil
+}
+
+// ExecPodBinaryStdin feeds input to command's stdin without exposing raw bytes
+// to the stream: input is base64 encoded locally and decoded in the container
+// by running the command as "base64 -d | command".
+func (c *Client) ExecPodBinaryStdin(command []string, input []byte, timeout time.Duration) error {
+	ok, err := c.HasBinary(context.Background(), "base64")
+	if err != nil {
+		return fmt.Errorf("failed to check for base64: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("base64 is not available in container %s", c.ContainerName)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(input)
+
+	var stderr bytes.Buffer
+	err = c.exec(context.Background(), execOptions{
+		command: shellCommand("base64 -d | " + shellJoin(command)),
+		explain: true,
+		stdin:   strings.NewReader(encoded),
+		stderr:  &stderr,
+		timeout: timeout,
+	})
+	if err != nil {
+		return withStderr(err, stderr.String())
+	}
+	return nil
+}
+
+// splitShellWords splits s into words the way a POSIX shell would, honoring
+// single quotes, double quotes and backslash escapes with their POSIX rules.
+// Expansions and operators are not interpreted.
+func splitShellWords(s string) ([]string, error) {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+		// wasInWord is inWord before an unquoted backslash, restored when
+		// the backslash turns out to start a line continuation.
+		wasInWord bool
+	)
+	for _, r := range s {
+		switch {
+		case escaped:
+			// A backslash-newline is a line continuation. Inside double
+			// quotes a backslash only escapes $, `, ", \ and newline and is
+			// otherwise kept.
+			switch {
+			case r == '\n':
+				if quote == 0 {
+					inWord = wasInWord
+				}
+			case quote == '"' && !strings.ContainsRune("$`\"\\", r):
+				word.WriteRune('\\')
+				word.WriteRune(r)
+			default:
+				word.WriteRune(r)
+			}
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, wasInWord, inWord = true, inWord, true
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in %q", quote, s)
+	}
+	if escaped {
+		return nil, fmt.Errorf("trailing backslash in %q", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// ErrCommandNotFound is returned by PrecheckCommand when the command's binary
+// is missing from the container.
+var ErrCommandNotFound = fmt.Errorf("command not found in container")
+
+// shellBuiltins are names PrecheckCommand doesn't look up since a shell
+// provides them without a binary.
+var shellBuiltins = map[string]bool{
+	".": true, ":": true, "[": true, "alias": true, "bg": true, "break": true,
+	"cd": true, "command": true, "continue": true, "echo": true, "eval": true,
+	"exec": true, "exit": true, "export": true, "false": true, "fg": true,
+	"jobs": true, "kill": true, "printf": true, "pwd": true, "read": true,
+	"readonly": true, "return": true, "set": true, "shift": true, "source": true,
+	"test": true, "times": true, "trap": true, "true": true, "type": true,
+	"ulimit": true, "umask": true, "unalias": true, "unset": true, "wait": true,
+}
+
+// PrecheckCommand checks that command[0] exists in the target container
+// before the command is run, so callers can report a missing tool clearly
+// instead of relaying a shell's "not found". Shell built-ins and absolute
+// paths, which may point at scripts, are not checked.
+func (c *Client) PrecheckCommand(ctx context.Context, command []string) error {
+	if len(command) == 0 {
+		return fmt.Errorf("empty command")
+	}
+	name := command[0]
+	if shellBuiltins[name] || strings.HasPrefix(name, "/") {
+		return nil
+	}
+
+	ok, err := c.HasBinary(ctx, name)
+	if err != nil {
+		return fmt.Errorf("failed to check for %s: %w", name, err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: %s is not installed in container %s", ErrCommandNotFound, name, c.ContainerName)
+	}
+	return nil
+}
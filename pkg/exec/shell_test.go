@@ -0,0 +1,69 @@
+package exec
+
+import (
+	"context"
+	"errors"
+	osexec "os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDetectShell(t *testing.T) {
+	c, _ := newTestClient(t, ClientOpt{})
+
+	shell, err := c.DetectShell(context.Background(), []string{"/nonexistent/bash", "/bin/sh"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shell != "/bin/sh" {
+		t.Errorf("DetectShell = %q, want %q", shell, "/bin/sh")
+	}
+
+	if _, err := c.DetectShell(context.Background(), []string{"/nonexistent/bash", "/nonexistent/sh"}); !errors.Is(err, ErrNoShell) {
+		t.Errorf("DetectShell without shells = %v, want ErrNoShell", err)
+	}
+}
+
+func TestDetectShellReturnsExecFailures(t *testing.T) {
+	c, _ := newTestClient(t, ClientOpt{})
+	c.Preflight = PreflightEnforce
+	c.cache.set("preflight\x00"+c.Namespace, preflightResult{err: deniedCreateExecErr}, time.Minute)
+
+	_, err := c.DetectShell(context.Background(), nil)
+	if !errors.Is(err, deniedCreateExecErr) {
+		t.Errorf("DetectShell with exec denied = %v, want the denial", err)
+	}
+}
+
+func TestSplitShellWords(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want []string
+	}{
+		{`a b  c`, []string{"a", "b", "c"}},
+		{`'a b' "c d"`, []string{"a b", "c d"}},
+		{`"a\b"`, []string{`a\b`}},
+		{`"\$x"`, []string{`$x`}},
+		{`"\"\\\` + "`" + `"`, []string{`"\` + "`"}},
+		{`a\b 'a\b'`, []string{"ab", `a\b`}},
+		{"a \\\nb", []string{"a", "b"}},
+		{"\"a\\\nb\"", []string{"ab"}},
+		{`'' x`, []string{"", "x"}},
+	} {
+		got, err := splitShellWords(tt.in)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitShellWords(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+			continue
+		}
+		// The inputs have no expansions, so sh must split them the same way.
+		out, err := osexec.Command("sh", "-c", `printf '%s\0' `+tt.in).Output()
+		if err != nil {
+			t.Fatalf("sh: %v", err)
+		}
+		if sh := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00"); !reflect.DeepEqual(sh, tt.want) {
+			t.Errorf("sh splits %q into %q, test wants %q", tt.in, sh, tt.want)
+		}
+	}
+}
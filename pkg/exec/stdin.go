@@ -0,0 +1,186 @@
+package exec
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+)
+
+// fileStdin wraps a stdin that is a pipe, FIFO or socket. Closing the write
+// end of those ends reads with io.EOF, which remotecommand forwards by closing
+// the remote stdin, but a socket peer going away shows up as ECONNRESET and
+// would be treated as a stream failure instead; fileStdin turns both into
+// io.EOF. Once the exec is over, release unblocks a read still waiting for
+// input, so the copy goroutine doesn't outlive the exec, and then hands the
+// file back to the caller without a deadline.
+type fileStdin struct {
+	f *os.File
+
+	mu       sync.Mutex
+	released bool
+	// reading counts the reads in flight on f.
+	reading sync.WaitGroup
+}
+
+// wrapFileStdin returns stdin wrapped in a fileStdin if it is a pipe, FIFO or
+// socket, and nil otherwise.
+func wrapFileStdin(stdin io.Reader) *fileStdin {
+	f, ok := stdin.(*os.File)
+	if !ok {
+		return nil
+	}
+	info, err := f.Stat()
+	if err != nil || info.Mode()&(os.ModeNamedPipe|os.ModeSocket) == 0 {
+		return nil
+	}
+	// Clear a deadline the caller may have left on the file. Files that don't
+	// support deadlines return an error, which is fine.
+	_ = f.SetReadDeadline(time.Time{})
+	return &fileStdin{f: f}
+}
+
+func (s *fileStdin) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	if s.released {
+		s.mu.Unlock()
+		return 0, io.EOF
+	}
+	s.reading.Add(1)
+	s.mu.Unlock()
+	defer s.reading.Done()
+
+	n, err := s.f.Read(p)
+	if errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, syscall.ECONNRESET) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// release interrupts a pending Read, waits for it to return and clears the
+// deadline again, so the caller can keep reading from the file after the
+// exec. Interrupting only works for files in non-blocking mode, such as the
+// ends of os.Pipe; reads from other files stay blocked until input arrives
+// and release doesn't wait for them.
+func (s *fileStdin) release() {
+	s.mu.Lock()
+	s.released = true
+	s.mu.Unlock()
+
+	if err := s.f.SetReadDeadline(time.Now()); err != nil {
+		return
+	}
+	s.reading.Wait()
+	_ = s.f.SetReadDeadline(time.Time{})
+}
+
+// stdinWriteGrace bounds how long stream waits, after the exec ended, for a
+// write to the remote stdin that is still in flight to fail or finish.
+const stdinWriteGrace = time.Second
+
+// stdinWrites records the first error writing to the remote command's stdin.
+// remotecommand copies stdin in a goroutine it doesn't wait for and only logs
+// the copy's errors, so this is how an exec learns of them.
+type stdinWrites struct {
+	mu      sync.Mutex
+	err     error
+	writing int
+	// idle is closed once no write is in flight, if someone waits for that.
+	idle chan struct{}
+}
+
+// wrap returns w with its write errors recorded.
+func (s *stdinWrites) wrap(w io.Writer) io.Writer {
+	return &stdinWriter{w: w, writes: s}
+}
+
+func (s *stdinWrites) write(w io.Writer, p []byte) (int, error) {
+	s.mu.Lock()
+	s.writing++
+	s.mu.Unlock()
+
+	n, err := w.Write(p)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writing--
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	if s.writing == 0 && s.idle != nil {
+		close(s.idle)
+		s.idle = nil
+	}
+	return n, err
+}
+
+// wait returns the recorded error once no write is in flight, or after
+// timeout regardless.
+func (s *stdinWrites) wait(timeout time.Duration) error {
+	s.mu.Lock()
+	if s.writing == 0 {
+		defer s.mu.Unlock()
+		return s.err
+	}
+	if s.idle == nil {
+		s.idle = make(chan struct{})
+	}
+	idle := s.idle
+	s.mu.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-idle:
+	case <-timer.C:
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+type stdinWriter struct {
+	w      io.Writer
+	writes *stdinWrites
+}
+
+func (w *stdinWriter) Write(p []byte) (int, error) {
+	return w.writes.write(w.w, p)
+}
+
+// stdinConnection records the write errors of the stdin stream created on
+// an upgraded exec connection.
+type stdinConnection struct {
+	httpstream.Connection
+	writes *stdinWrites
+}
+
+func (c *stdinConnection) CreateStream(headers http.Header) (httpstream.Stream, error) {
+	stream, err := c.Connection.CreateStream(headers)
+	if err != nil || headers.Get(corev1.StreamType) != corev1.StreamTypeStdin {
+		return stream, err
+	}
+	return &stdinStream{Stream: stream, writes: c.writes}, nil
+}
+
+type stdinStream struct {
+	httpstream.Stream
+	writes *stdinWrites
+}
+
+func (s *stdinStream) Write(p []byte) (int, error) {
+	return s.writes.write(s.Stream, p)
+}
+
+// brokenPipe reports whether err is a write to a pipe or stream whose reader
+// is gone.
+func brokenPipe(err error) bool {
+	return errors.Is(err, syscall.EPIPE) || strings.Contains(err.Error(), "broken pipe")
+}
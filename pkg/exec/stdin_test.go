@@ -0,0 +1,68 @@
+package exec
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestExecPodPipeStdinEOF(t *testing.T) {
+	c, _ := newTestClient(t, ClientOpt{})
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	go func() {
+		w.Write([]byte("hello over a pipe"))
+		w.Close()
+	}()
+
+	var stdout bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- c.ExecPod([]string{"cat"}, r, &stdout, nil, false, time.Minute)
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("remote command didn't see EOF after the write end was closed")
+	}
+	if got := stdout.String(); got != "hello over a pipe" {
+		t.Errorf("remote read %q, want %q", got, "hello over a pipe")
+	}
+}
+
+func TestExecPodPipeStdinUsableAfterExec(t *testing.T) {
+	c, _ := newTestClient(t, ClientOpt{})
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+
+	// The command doesn't read its stdin, so the read of the stream's copy
+	// is still pending when the exec ends.
+	if err := c.ExecPod([]string{"true"}, r, nil, nil, false, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Write([]byte("later")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len("later"))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatalf("reading stdin after the exec: %v", err)
+	}
+	if string(buf) != "later" {
+		t.Errorf("read %q after the exec, want %q", buf, "later")
+	}
+}
@@ -0,0 +1,425 @@
+package exec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// abortWriter hands every write to fn and, the first time fn fails, records
+// the error and cancels the stream. remotecommand only logs stdout write
+// errors, so cancelling is what actually stops the remote output.
+type abortWriter struct {
+	fn     func(p []byte) error
+	cancel context.CancelFunc
+
+	mu  sync.Mutex
+	err error
+}
+
+func (w *abortWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	if err := w.fn(p); err != nil {
+		w.err = err
+		w.cancel()
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeAll adapts w for abortWriter, turning short writes into errors.
+func writeAll(w io.Writer) func(p []byte) error {
+	return func(p []byte) error {
+		n, err := w.Write(p)
+		if err == nil && n < len(p) {
+			err = io.ErrShortWrite
+		}
+		return err
+	}
+}
+
+// Err returns the error that aborted the stream, if any.
+func (w *abortWriter) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// ExecPodPublish runs command and passes each chunk of its stdout to publish
+// as it arrives, in order, e.g. to forward it to a message bus. publish gets
+// its own copy of the chunk. If publish fails the stream is torn down and
+// that error is returned.
+func (c *Client) ExecPodPublish(ctx context.Context, command []string, publish func(chunk []byte) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	out := &abortWriter{
+		fn: func(p []byte) error {
+			return publish(append([]byte(nil), p...))
+		},
+		cancel: cancel,
+	}
+
+	var stderr bytes.Buffer
+	err := c.exec(ctx, execOptions{
+		command: command,
+		explain: true,
+		stdout:  out,
+		stderr:  &stderr,
+	})
+	if pubErr := out.Err(); pubErr != nil {
+		return fmt.Errorf("failed to publish output: %w", pubErr)
+	}
+	if err != nil {
+		return withStderr(err, stderr.String())
+	}
+	return nil
+}
+
+// ErrConsumerStalled is returned by ExecPodBuffered when the output buffer
+// stayed full for longer than the stall timeout.
+var ErrConsumerStalled = fmt.Errorf("output consumer stalled")
+
+// ExecPodBuffered runs command and delivers its stdout to out through a
+// buffer of bufferSize chunks. While the buffer is full the stream is not
+// read, so a slow out throttles the remote command instead of piling up
+// memory. If the buffer stays full for longer than stallTimeout, the stream
+// is aborted with ErrConsumerStalled. A stallTimeout of zero waits forever.
+func (c *Client) ExecPodBuffered(ctx context.Context, command []string, out io.Writer, bufferSize int, stallTimeout time.Duration) error {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	chunks := make(chan []byte, bufferSize)
+	done := make(chan struct{})
+	var outErr error
+	go func() {
+		defer close(done)
+		for chunk := range chunks {
+			if outErr != nil {
+				continue
+			}
+			if _, err := out.Write(chunk); err != nil {
+				outErr = err
+				cancel()
+			}
+		}
+	}()
+
+	buffered := &abortWriter{
+		fn: func(p []byte) error {
+			chunk := append([]byte(nil), p...)
+			var stalled <-chan time.Time
+			if stallTimeout > 0 {
+				timer := time.NewTimer(stallTimeout)
+				defer timer.Stop()
+				stalled = timer.C
+			}
+			select {
+			case chunks <- chunk:
+				return nil
+			case <-stalled:
+				return ErrConsumerStalled
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+		cancel: cancel,
+	}
+
+	var stderr bytes.Buffer
+	err := c.exec(ctx, execOptions{
+		command: command,
+		explain: true,
+		stdout:  buffered,
+		stderr:  &stderr,
+	})
+	close(chunks)
+	<-done
+
+	if outErr != nil {
+		return fmt.Errorf("failed to write output: %w", outErr)
+	}
+	if bufErr := buffered.Err(); bufErr != nil && errors.Is(bufErr, ErrConsumerStalled) {
+		return bufErr
+	}
+	if err != nil {
+		return withStderr(err, stderr.String())
+	}
+	return nil
+}
+
+// ExecPodSSE runs command and streams its combined stdout and stderr to w as
+// Server-Sent Events, one "data:" event per line, flushed as it arrives. When
+// the command ends an "exit" event carries its exit code, or an "error" event
+// the failure if there is no exit code. Pass the request's context as ctx so
+// a disconnecting client tears the exec down.
+func (c *Client) ExecPodSSE(ctx context.Context, command []string, w http.ResponseWriter) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return fmt.Errorf("response writer does not support flushing")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var mu sync.Mutex
+	send := func(event, data string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		var msg strings.Builder
+		if event != "" {
+			msg.WriteString("event: " + event + "\n")
+		}
+		for _, line := range strings.Split(data, "\n") {
+			msg.WriteString("data: " + line + "\n")
+		}
+		msg.WriteString("\n")
+		if _, err := io.WriteString(w, msg.String()); err != nil {
+			cancel()
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+
+	stdout := newLineWriter(func(line string) error { return send("", line) })
+	stderr := newLineWriter(func(line string) error { return send("", line) })
+	err := c.exec(ctx, execOptions{
+		command: command,
+		explain: true,
+		stdout:  stdout,
+		stderr:  stderr,
+	})
+	stdout.Flush()
+	stderr.Flush()
+
+	if code, ok := exitCode(err); ok || err == nil {
+		if sendErr := send("exit", strconv.Itoa(code)); sendErr != nil && err == nil {
+			return sendErr
+		}
+		return err
+	}
+	if ctx.Err() == nil {
+		send("error", err.Error())
+	}
+	return err
+}
+
+// ExecReader is the io.ReadCloser returned by ExecPodReadCloser. Read yields
+// the command's stdout and returns io.EOF once the stream has ended; Err then
+// reports how the command finished.
+type ExecReader struct {
+	pr     *io.PipeReader
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error
+}
+
+// Read implements io.Reader.
+func (r *ExecReader) Read(p []byte) (int, error) {
+	return r.pr.Read(p)
+}
+
+// Close aborts the stream if it is still running and waits for it to end.
+func (r *ExecReader) Close() error {
+	r.cancel()
+	r.pr.Close()
+	<-r.done
+	return nil
+}
+
+// Err returns the error the command finished with, including a non-zero exit
+// status, annotated with its stderr. It is nil while the command is still
+// running and after a successful run.
+func (r *ExecReader) Err() error {
+	select {
+	case <-r.done:
+		return r.err
+	default:
+		return nil
+	}
+}
+
+// ExecPodReadCloser starts command and returns its stdout as a stream, for
+// callers that want to process output with the standard io utilities. The
+// returned value is an *ExecReader; once Read has returned io.EOF its Err
+// reports the exit status. Closing it before EOF aborts the command.
+func (c *Client) ExecPodReadCloser(ctx context.Context, command []string) (io.ReadCloser, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	r := &ExecReader{pr: pr, cancel: cancel, done: make(chan struct{})}
+
+	go func() {
+		defer cancel()
+		stderr := &limitedBuffer{limit: c.MaxCaptureBytes}
+		err := c.exec(ctx, execOptions{
+			command: command,
+			explain: true,
+			stdout:  pw,
+			stderr:  stderr,
+		})
+		if err != nil {
+			r.err = withStderr(err, c.decodeOutput(stderr.Bytes()))
+		}
+		close(r.done)
+		pw.Close()
+	}()
+	return r, nil
+}
+
+// errMarkerSeen stops the line scan of ExecPodUntilMarker once the marker
+// line went by.
+var errMarkerSeen = errors.New("marker seen")
+
+// ExecPodUntilMarker runs command and echoes its stdout to out line by line
+// until a line containing marker shows up, e.g. a "ready" log line of a
+// server started in the container. It then tears the stream down and returns
+// nil; the marker line is the last one written to out. It fails if the
+// command ends before printing the marker.
+func (c *Client) ExecPodUntilMarker(ctx context.Context, command []string, marker string, out io.Writer) error {
+	if marker == "" {
+		return fmt.Errorf("empty marker")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	seen := false
+	lines := newLineWriter(func(line string) error {
+		if _, err := io.WriteString(out, line+"\n"); err != nil {
+			return err
+		}
+		if strings.Contains(line, marker) {
+			seen = true
+			cancel()
+			return errMarkerSeen
+		}
+		return nil
+	})
+
+	var stderr bytes.Buffer
+	err := c.exec(ctx, execOptions{
+		command: command,
+		explain: true,
+		stdout:  lines,
+		stderr:  &stderr,
+	})
+	if flushErr := lines.Flush(); flushErr != nil && !errors.Is(flushErr, errMarkerSeen) {
+		return fmt.Errorf("failed to write output: %w", flushErr)
+	}
+	if seen {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("command ended before marker %q appeared: %w", marker, withStderr(err, stderr.String()))
+	}
+	return fmt.Errorf("command exited before marker %q appeared", marker)
+}
+
+const (
+	ansiHighlight = "\x1b[1;31m"
+	ansiReset     = "\x1b[0m"
+)
+
+// ExecPodAnnotated runs command and writes its stdout and stderr to out line
+// by line, each prefixed with its line number, e.g. for operators scanning
+// verbose output. When out is a terminal, matches of highlight are shown in
+// color unless NoColor is set. highlight may be nil.
+func (c *Client) ExecPodAnnotated(ctx context.Context, command []string, highlight *regexp.Regexp, out io.Writer) error {
+	color := false
+	if f, ok := out.(*os.File); ok && !c.NoColor {
+		color = isTerminal(int(f.Fd()))
+	}
+
+	var mu sync.Mutex
+	number := 0
+	annotate := func(line string) error {
+		if highlight != nil && color {
+			line = highlight.ReplaceAllStringFunc(line, func(match string) string {
+				return ansiHighlight + match + ansiReset
+			})
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		number++
+		_, err := fmt.Fprintf(out, "%6d  %s\n", number, line)
+		return err
+	}
+
+	stdout, stderr := newLineWriter(annotate), newLineWriter(annotate)
+	err := c.exec(ctx, execOptions{
+		command: command,
+		explain: true,
+		stdout:  stdout,
+		stderr:  stderr,
+	})
+	if flushErr := errors.Join(stdout.Flush(), stderr.Flush()); flushErr != nil && err == nil {
+		return fmt.Errorf("failed to write output: %w", flushErr)
+	}
+	return err
+}
+
+// ExecPodTransform runs command and writes each chunk of its stdout to out
+// after passing it through transform, e.g. to mask secrets or rewrite output
+// on the fly. transform gets its own copy of the chunk and may modify it.
+//
+// Chunks are cut wherever the stream happens to deliver data, not at line or
+// token boundaries, so a pattern split across two chunks is missed by a
+// transform that looks at each chunk alone. Transforms matching such patterns
+// have to buffer themselves: hold back a possibly incomplete tail of each
+// chunk, up to the last newline for instance, and prepend it to the next.
+// Whatever is still held back when the command ends is lost.
+func (c *Client) ExecPodTransform(ctx context.Context, command []string, transform func([]byte) []byte, out io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	write := writeAll(out)
+	stdout := &abortWriter{
+		fn: func(p []byte) error {
+			return write(transform(append([]byte(nil), p...)))
+		},
+		cancel: cancel,
+	}
+
+	var stderr bytes.Buffer
+	err := c.exec(ctx, execOptions{
+		command: command,
+		explain: true,
+		stdout:  stdout,
+		stderr:  &stderr,
+	})
+	if writeErr := stdout.Err(); writeErr != nil {
+		return fmt.Errorf("failed to write output: %w", writeErr)
+	}
+	if err != nil {
+		return withStderr(err, stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package exec
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// failingWriter accepts limit bytes and fails every write after that with
+// err.
+type failingWriter struct {
+	limit   int
+	written int
+	err     error
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.written+len(p) > w.limit {
+		n := w.limit - w.written
+		w.written = w.limit
+		return n, w.err
+	}
+	w.written += len(p)
+	return len(p), nil
+}
+
+func TestStopOnStdoutClose(t *testing.T) {
+	errDiskFull := errors.New("disk full")
+	tests := []struct {
+		name    string
+		err     error
+		wantErr error
+	}{
+		{"closed pipe", io.ErrClosedPipe, nil},
+		{"other error", errDiskFull, errDiskFull},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := newTestClient(t, ClientOpt{StopOnStdoutClose: true})
+			w := &failingWriter{limit: 4096, err: tt.err}
+
+			done := make(chan error, 1)
+			go func() {
+				// yes never stops by itself.
+				done <- c.ExecPod([]string{"yes"}, nil, w, nil, false, time.Minute)
+			}()
+			select {
+			case err := <-done:
+				if tt.wantErr == nil && err != nil {
+					t.Errorf("ExecPod = %v, want success", err)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Errorf("ExecPod = %v, want %v", err, tt.wantErr)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatal("stream kept running after stdout failed")
+			}
+			if w.written != w.limit {
+				t.Errorf("writer got %d bytes, want %d", w.written, w.limit)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateFuncs are available to ExecPodTemplate templates. quote turns any
+// value into a single shell word so interpolated data can't add arguments.
+var templateFuncs = template.FuncMap{
+	"quote": func(v any) string {
+		return shellQuote(fmt.Sprint(v))
+	},
+}
+
+// ExecPodTemplate renders tmpl with data, splits the result into arguments
+// like a shell would and runs them directly, without a shell. Values should
+// be interpolated with {{quote .Value}} so they always end up as exactly one
+// argument.
+func (c *Client) ExecPodTemplate(tmpl string, data any, timeout time.Duration) (stdout, stderr string, err error) {
+	t, err := template.New("command").Funcs(templateFuncs).Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to parse command template: %w", err)
+	}
+
+	var rendered strings.Builder
+	if err := t.Execute(&rendered, data); err != nil {
+		return "", "", fmt.Errorf("failed to render command template: %w", err)
+	}
+
+	command, err := splitShellWords(rendered.String())
+	if err != nil {
+		return "", "", err
+	}
+	if len(command) == 0 {
+		return "", "", fmt.Errorf("command template rendered to an empty command")
+	}
+
+	return c.captureExplained(context.Background(), command, timeout)
+}
@@ -0,0 +1,73 @@
+package exec
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// injectionAttempts are values that would run touch on path if they escaped
+// their argument.
+func injectionAttempts(path string) []string {
+	return []string{
+		"'; touch " + path + "; '",
+		`"; touch ` + path + `; "`,
+		"$(touch " + path + ")",
+		"`touch " + path + "`",
+		"x; touch " + path,
+		"x && touch " + path,
+		"x | touch " + path,
+		"x\ntouch " + path,
+		`\'; touch ` + path + `; \'`,
+		"",
+		"it's \"quoted\" \\ ${HOME}",
+	}
+}
+
+func TestTemplateQuoteKeepsOneArgument(t *testing.T) {
+	for _, value := range injectionAttempts("/tmp/pwned") {
+		rendered, err := renderTestTemplate("echo {{quote .}} done", value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		words, err := splitShellWords(rendered)
+		if err != nil {
+			t.Fatalf("splitting %q: %v", rendered, err)
+		}
+		if len(words) != 3 || words[0] != "echo" || words[1] != value || words[2] != "done" {
+			t.Errorf("value %q rendered to words %q", value, words)
+		}
+	}
+}
+
+func TestExecPodTemplateInjection(t *testing.T) {
+	c, _ := newTestClient(t, ClientOpt{})
+	path := filepath.Join(t.TempDir(), "pwned")
+
+	for _, value := range injectionAttempts(path) {
+		// The value passes through a shell inside the container as well.
+		stdout, _, err := c.ExecPodTemplate(`sh -c 'printf "%s" "$1"' sh {{quote .Value}}`, map[string]string{"Value": value}, time.Minute)
+		if err != nil {
+			t.Fatalf("value %q: %v", value, err)
+		}
+		if stdout != value {
+			t.Errorf("value %q came out as %q", value, stdout)
+		}
+		if _, err := os.Stat(path); err == nil {
+			t.Fatalf("value %q ran an injected command", value)
+		}
+	}
+}
+
+func renderTestTemplate(tmpl string, data any) (string, error) {
+	t, err := template.New("test").Funcs(templateFuncs).Parse(tmpl)
+	if err != nil {
+		return "", err
+	}
+	var out strings.Builder
+	err = t.Execute(&out, data)
+	return out.String(), err
+}
@@ -0,0 +1,61 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+)
+
+// ErrPodTerminating is returned when the target pod is being deleted and the
+// client's TerminatingPods policy is TerminatingReject.
+var ErrPodTerminating = fmt.Errorf("pod is terminating")
+
+// TerminatingPolicy decides what execs do when the target pod is being
+// deleted. Streams into a terminating pod can be cut off at any time, in the
+// middle of a command, when the kubelet kills the container.
+type TerminatingPolicy int
+
+const (
+	// TerminatingIgnore does not check the pod and execs as usual.
+	TerminatingIgnore TerminatingPolicy = iota
+	// TerminatingReject fails execs into a terminating pod with
+	// ErrPodTerminating.
+	TerminatingReject
+	// TerminatingLastGasp runs the exec anyway, e.g. for a last diagnostic,
+	// but cuts it short at the end of the pod's grace period.
+	TerminatingLastGasp
+)
+
+// lastGaspMinTimeout is the least time a TerminatingLastGasp exec gets, even
+// when the grace period is already over.
+const lastGaspMinTimeout = 5 * time.Second
+
+// terminatingTimeout applies the TerminatingPods policy. It returns how long
+// the exec may run, or zero for no extra limit.
+func (c *Client) terminatingTimeout(ctx context.Context) (time.Duration, error) {
+	if c.TerminatingPods == TerminatingIgnore {
+		return 0, nil
+	}
+
+	pod, err := c.getPod(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if pod.DeletionTimestamp == nil {
+		return 0, nil
+	}
+
+	if c.TerminatingPods == TerminatingReject {
+		return 0, fmt.Errorf("%w: %s/%s must terminate by %s", ErrPodTerminating, pod.Namespace, pod.Name, pod.DeletionTimestamp.Format(time.RFC3339))
+	}
+
+	timeout := time.Until(pod.DeletionTimestamp.Time)
+	if timeout < lastGaspMinTimeout {
+		timeout = lastGaspMinTimeout
+	}
+	log.Warn("exec into terminating pod, output may be cut off", zap.String("namespace", pod.Namespace), zap.String("pod", pod.Name), zap.String("timeout", timeout.String()))
+	return timeout, nil
+}
@@ -0,0 +1,223 @@
+package exec
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/third_party/forked/golang/netutil"
+)
+
+// setupRoundTripper upgrades exec requests to SPDY like spdy.SpdyRoundTripper
+// does, but bounds connection setup in two separate steps: the dialer's
+// timeout covers each TCP connection, to the API server or to a proxy, and
+// handshakeTimeout covers the TLS handshake on top of it. The stock round
+// tripper can only bound both together, and not the handshake with the API
+// server through a proxy at all.
+type setupRoundTripper struct {
+	tlsConfig        *tls.Config
+	proxier          func(*http.Request) (*url.URL, error)
+	dialer           *net.Dialer
+	handshakeTimeout time.Duration
+	pingPeriod       time.Duration
+
+	followRedirects          bool
+	requireSameHostRedirects bool
+
+	// conn is the upgraded connection once RoundTrip succeeded.
+	conn net.Conn
+}
+
+func newSetupRoundTripper(cfg spdy.RoundTripperConfig, opts TransportOptions) *setupRoundTripper {
+	proxier := cfg.Proxier
+	if proxier == nil {
+		proxier = utilnet.NewProxierWithNoProxyCIDR(http.ProxyFromEnvironment)
+	}
+	return &setupRoundTripper{
+		tlsConfig:                cfg.TLS,
+		proxier:                  proxier,
+		dialer:                   &net.Dialer{Timeout: opts.DialTimeout, KeepAlive: opts.TCPKeepAlive},
+		handshakeTimeout:         opts.TLSHandshakeTimeout,
+		pingPeriod:               cfg.PingPeriod,
+		followRedirects:          cfg.FollowRedirects,
+		requireSameHostRedirects: cfg.RequireSameHostRedirects,
+	}
+}
+
+// TLSClientConfig implements utilnet.TLSClientConfigHolder.
+func (rt *setupRoundTripper) TLSClientConfig() *tls.Config {
+	return rt.tlsConfig
+}
+
+// RoundTrip sends req with the upgrade headers and reads the response. On
+// success the connection is kept for NewConnection.
+func (rt *setupRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	header := utilnet.CloneHeader(req.Header)
+	header.Add(httpstream.HeaderConnection, httpstream.HeaderUpgrade)
+	header.Add(httpstream.HeaderUpgrade, spdy.HeaderSpdy31)
+
+	var (
+		conn        net.Conn
+		rawResponse []byte
+		err         error
+	)
+	if rt.followRedirects {
+		conn, rawResponse, err = utilnet.ConnectWithRedirects(req.Method, req.URL, header, req.Body, rt, rt.requireSameHostRedirects)
+	} else {
+		clone := utilnet.CloneRequest(req)
+		clone.Header = header
+		conn, err = rt.Dial(clone)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(io.MultiReader(bytes.NewReader(rawResponse), conn)), nil)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	rt.conn = conn
+	return resp, nil
+}
+
+// Dial implements utilnet.Dialer: it connects to the host of req and writes
+// req to the connection.
+func (rt *setupRoundTripper) Dial(req *http.Request) (net.Conn, error) {
+	conn, err := rt.dial(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// dial connects to the host of req, through a proxy if the proxier names one.
+func (rt *setupRoundTripper) dial(req *http.Request) (net.Conn, error) {
+	ctx := req.Context()
+	proxyURL, err := rt.proxier(req)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return rt.dialURL(ctx, req.URL)
+	}
+
+	conn, err := rt.dialURL(ctx, proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	target := netutil.CanonicalAddr(req.URL)
+	connectReq := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: target},
+		Host:   target,
+		Header: http.Header{},
+	}
+	if proxyURL.User != nil {
+		connectReq.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(proxyURL.User.String())))
+	}
+	if err := connectReq.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect through proxy %s: %w", proxyURL.Host, err)
+	}
+	// The proxy sends nothing after its response until the tunnel is used,
+	// so the reader can't swallow bytes of the target connection.
+	resp, err := http.ReadResponse(bufio.NewReader(conn), connectReq)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect through proxy %s: %w", proxyURL.Host, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused to connect to %s: %s", proxyURL.Host, target, resp.Status)
+	}
+
+	if req.URL.Scheme != "https" {
+		return conn, nil
+	}
+	return rt.handshake(ctx, conn, target)
+}
+
+// dialURL connects to the host of u, with TLS for https.
+func (rt *setupRoundTripper) dialURL(ctx context.Context, u *url.URL) (net.Conn, error) {
+	addr := netutil.CanonicalAddr(u)
+	conn, err := rt.dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" {
+		return conn, nil
+	}
+	return rt.handshake(ctx, conn, addr)
+}
+
+// handshake runs the TLS handshake with addr over conn, bounded by
+// handshakeTimeout. The server's certificate is verified against the
+// configured server name, or the host of addr.
+func (rt *setupRoundTripper) handshake(ctx context.Context, conn net.Conn, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConfig := rt.tlsConfig
+	switch {
+	case tlsConfig == nil:
+		tlsConfig = &tls.Config{ServerName: host}
+	case tlsConfig.ServerName == "":
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.ServerName = host
+	}
+
+	if rt.handshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rt.handshakeTimeout)
+		defer cancel()
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("TLS handshake with %s failed: %w", addr, err)
+	}
+	return tlsConn, nil
+}
+
+// NewConnection implements spdy.Upgrader: it checks that resp upgraded the
+// connection and sets up a SPDY connection on it.
+func (rt *setupRoundTripper) NewConnection(resp *http.Response) (httpstream.Connection, error) {
+	connection := strings.ToLower(resp.Header.Get(httpstream.HeaderConnection))
+	upgrade := strings.ToLower(resp.Header.Get(httpstream.HeaderUpgrade))
+	if resp.StatusCode != http.StatusSwitchingProtocols || !strings.Contains(connection, strings.ToLower(httpstream.HeaderUpgrade)) || !strings.Contains(upgrade, strings.ToLower(spdy.HeaderSpdy31)) {
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("unable to upgrade connection: unable to read error from server response")
+		}
+		var status metav1.Status
+		if json.Unmarshal(body, &status) == nil && status.Kind == "Status" {
+			return nil, &apierrors.StatusError{ErrStatus: status}
+		}
+		return nil, fmt.Errorf("unable to upgrade connection: %s", strings.TrimSpace(string(body)))
+	}
+	return spdy.NewClientConnectionWithPings(rt.conn, rt.pingPeriod)
+}
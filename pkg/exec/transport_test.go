@@ -0,0 +1,75 @@
+package exec
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
+)
+
+func TestSetupRoundTripperHandshakeTimeout(t *testing.T) {
+	// A server that accepts connections but never answers the handshake.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	rt := newSetupRoundTripper(spdy.RoundTripperConfig{
+		Proxier: func(*http.Request) (*url.URL, error) { return nil, nil },
+	}, TransportOptions{DialTimeout: time.Minute, TLSHandshakeTimeout: 100 * time.Millisecond})
+	req, err := http.NewRequest("POST", "https://"+ln.Addr().String()+"/exec", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	_, err = rt.RoundTrip(req)
+	if err == nil || !strings.Contains(err.Error(), "TLS handshake") {
+		t.Fatalf("RoundTrip = %v, want a TLS handshake error", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("handshake took %s, want it bounded by TLSHandshakeTimeout", elapsed)
+	}
+}
+
+func TestSetupRoundTripperStatusError(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(`{"kind":"Status","apiVersion":"v1","status":"Failure","reason":"Forbidden","code":403}`))
+	}))
+	defer ts.Close()
+
+	rt := newSetupRoundTripper(spdy.RoundTripperConfig{
+		TLS:     ts.Client().Transport.(*http.Transport).TLSClientConfig,
+		Proxier: func(*http.Request) (*url.URL, error) { return nil, nil },
+	}, TransportOptions{DialTimeout: time.Minute, TLSHandshakeTimeout: time.Minute})
+	req, err := http.NewRequest("POST", ts.URL+"/exec", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rt.NewConnection(resp); !apierrors.IsForbidden(err) {
+		t.Errorf("NewConnection = %v, want a Forbidden status error", err)
+	}
+}
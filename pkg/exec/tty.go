@@ -0,0 +1,273 @@
+package exec
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	"golang.org/x/term"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// isTerminal reports whether fd refers to a terminal. It is a variable so the
+// TTY detection can be faked.
+var isTerminal = term.IsTerminal
+
+// terminalSize returns the current size of the terminal behind fd.
+var terminalSize = func(fd int) (*remotecommand.TerminalSize, error) {
+	width, height, err := term.GetSize(fd)
+	if err != nil {
+		return nil, err
+	}
+	return &remotecommand.TerminalSize{Width: uint16(width), Height: uint16(height)}, nil
+}
+
+// resizePollInterval is how often the local terminal size is checked.
+const resizePollInterval = 250 * time.Millisecond
+
+// terminalSizeQueue implements remotecommand.TerminalSizeQueue by polling the
+// size of a local terminal and emitting it whenever it changes.
+type terminalSizeQueue struct {
+	ch chan *remotecommand.TerminalSize
+}
+
+// newTerminalSizeQueue starts watching fd until ctx is done.
+func newTerminalSizeQueue(ctx context.Context, fd int) *terminalSizeQueue {
+	q := &terminalSizeQueue{ch: make(chan *remotecommand.TerminalSize, 1)}
+	go func() {
+		defer close(q.ch)
+
+		ticker := time.NewTicker(resizePollInterval)
+		defer ticker.Stop()
+
+		var last remotecommand.TerminalSize
+		for {
+			if size, err := terminalSize(fd); err == nil && *size != last {
+				last = *size
+				select {
+				case q.ch <- size:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return q
+}
+
+func (q *terminalSizeQueue) Next() *remotecommand.TerminalSize {
+	size, ok := <-q.ch
+	if !ok {
+		return nil
+	}
+	return size
+}
+
+// ExecPodAuto is like ExecPod but decides whether to allocate a TTY itself. If
+// stdout is a terminal the command runs with a TTY, stdin is put into raw mode
+// when it is a terminal too and local resizes are forwarded; otherwise the
+// command runs without a TTY and stderr is kept separate.
+func (c *Client) ExecPodAuto(command []string, stdin io.Reader, stdout, stderr io.Writer, timeout time.Duration) error {
+	out, ok := stdout.(*os.File)
+	if !ok || !isTerminal(int(out.Fd())) {
+		return c.ExecPod(command, stdin, stdout, stderr, false, timeout)
+	}
+
+	if in, ok := stdin.(*os.File); ok && isTerminal(int(in.Fd())) {
+		state, err := term.MakeRaw(int(in.Fd()))
+		if err == nil {
+			defer term.Restore(int(in.Fd()), state)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	return c.exec(ctx, execOptions{
+		command:   command,
+		explain:   true,
+		stdin:     stdin,
+		stdout:    stdout,
+		tty:       true,
+		sizeQueue: newTerminalSizeQueue(ctx, int(out.Fd())),
+		timeout:   timeout,
+	})
+}
+
+// defaultTerminalSize is assumed when the local stdout is not a terminal.
+var defaultTerminalSize = remotecommand.TerminalSize{Width: 80, Height: 24}
+
+// castWriter writes what passes through it as asciinema v2 output events,
+// timed relative to start. Events must hold valid UTF-8, so a multi-byte
+// character split across writes is held back until it is complete.
+type castWriter struct {
+	mu      sync.Mutex
+	out     io.Writer
+	start   time.Time
+	pending []byte
+	err     error
+}
+
+func (w *castWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return len(p), nil
+	}
+
+	data := append(w.pending, p...)
+	cut := len(data) - incompleteUTF8(data)
+	w.pending = append([]byte(nil), data[cut:]...)
+	if cut == 0 {
+		return len(p), nil
+	}
+
+	w.err = w.event(data[:cut])
+	return len(p), nil
+}
+
+// Flush writes out bytes still held back as an incomplete character, for
+// when the stream has ended and they are not going to be completed.
+func (w *castWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil || len(w.pending) == 0 {
+		return w.err
+	}
+	data := w.pending
+	w.pending = nil
+	w.err = w.event(data)
+	return w.err
+}
+
+// event writes data as an output event. w.mu must be held.
+func (w *castWriter) event(data []byte) error {
+	event, err := json.Marshal([]interface{}{time.Since(w.start).Seconds(), "o", string(data)})
+	if err != nil {
+		return err
+	}
+	_, err = w.out.Write(append(event, '\n'))
+	return err
+}
+
+// RecordExec runs command interactively with a TTY, wired to the local
+// stdin and stdout, and records the session to out in asciinema v2 cast
+// format. The header carries the local terminal size, or 80x24 when stdout is
+// not a terminal.
+func (c *Client) RecordExec(command []string, out io.Writer) error {
+	size := defaultTerminalSize
+	stdoutFd := int(os.Stdout.Fd())
+	if isTerminal(stdoutFd) {
+		if s, err := terminalSize(stdoutFd); err == nil {
+			size = *s
+		}
+	}
+
+	start := time.Now()
+	header, err := json.Marshal(map[string]interface{}{
+		"version":   2,
+		"width":     size.Width,
+		"height":    size.Height,
+		"timestamp": start.Unix(),
+		"title":     strings.Join(command, " "),
+		"env":       map[string]string{"TERM": os.Getenv("TERM")},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(append(header, '\n')); err != nil {
+		return fmt.Errorf("failed to write cast header: %w", err)
+	}
+
+	stdinFd := int(os.Stdin.Fd())
+	if isTerminal(stdinFd) {
+		if state, err := term.MakeRaw(stdinFd); err == nil {
+			defer term.Restore(stdinFd, state)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := execOptions{
+		command: command,
+		explain: true,
+		stdin:   os.Stdin,
+		tty:     true,
+	}
+	cast := &castWriter{out: out, start: start}
+	opts.stdout = io.MultiWriter(os.Stdout, cast)
+	if isTerminal(stdoutFd) {
+		opts.sizeQueue = newTerminalSizeQueue(ctx, stdoutFd)
+	} else {
+		opts.sizeQueue = &fixedSizeQueue{size: &size}
+	}
+
+	err = c.exec(ctx, opts)
+	if castErr := cast.Flush(); castErr != nil {
+		return fmt.Errorf("failed to write cast: %w", castErr)
+	}
+	return err
+}
+
+// fixedSizeQueue reports a single terminal size.
+type fixedSizeQueue struct {
+	size *remotecommand.TerminalSize
+}
+
+func (q *fixedSizeQueue) Next() *remotecommand.TerminalSize {
+	size := q.size
+	q.size = nil
+	return size
+}
+
+// ExecPodTTYWithStderrCapture runs command with a TTY wired to stdin and
+// stdout but still returns its stderr separately, which a TTY alone can't do
+// as it merges both streams. The command runs in a shell with stderr
+// redirected to a uniquely named file under /tmp, which a second exec reads
+// back once the command is done and a third one removes, also after a
+// failure. Those extra round trips make it slower than a plain exec.
+func (c *Client) ExecPodTTYWithStderrCapture(command []string, stdin io.Reader, stdout io.Writer, timeout time.Duration) (stderr string, err error) {
+	if len(command) == 0 {
+		return "", fmt.Errorf("empty command")
+	}
+	ctx := context.Background()
+	path := "/tmp/k8sutils-stderr-" + utilrand.String(10)
+
+	defer func() {
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		if _, rmStderr, rmErr := c.capture(cleanupCtx, []string{"rm", "-f", path}, cleanupTimeout); rmErr != nil {
+			log.Warn("failed to remove stderr capture file", zap.String("path", path), zap.Error(withStderr(rmErr, rmStderr)))
+		}
+	}()
+
+	err = c.exec(ctx, execOptions{
+		command: shellCommand(shellJoin(command) + " 2>" + shellQuote(path)),
+		explain: true,
+		stdin:   stdin,
+		stdout:  stdout,
+		tty:     true,
+		timeout: timeout,
+	})
+
+	data, readErr := c.ReadFileFromPod(ctx, path)
+	if readErr != nil {
+		return "", errors.Join(err, fmt.Errorf("failed to read back stderr: %w", readErr))
+	}
+	return c.decodeOutput(data), err
+}
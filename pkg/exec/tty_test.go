@@ -0,0 +1,89 @@
+package exec
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// fakeTerminal makes fd look like a terminal of the given size for the
+// duration of the test.
+func fakeTerminal(t *testing.T, fd int, size remotecommand.TerminalSize) {
+	t.Helper()
+	origIsTerminal, origSize := isTerminal, terminalSize
+	isTerminal = func(f int) bool { return f == fd }
+	terminalSize = func(int) (*remotecommand.TerminalSize, error) {
+		s := size
+		return &s, nil
+	}
+	t.Cleanup(func() { isTerminal, terminalSize = origIsTerminal, origSize })
+}
+
+func TestExecPodAutoNonTerminal(t *testing.T) {
+	c, execs := newTestClient(t, ClientOpt{})
+
+	var stdout, stderr bytes.Buffer
+	err := c.ExecPodAuto([]string{"sh", "-c", "echo out; echo err >&2"}, nil, &stdout, &stderr, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := execs.last(t)
+	if record.tty {
+		t.Error("exec to a non-terminal stdout allocated a TTY")
+	}
+	if !record.stderr {
+		t.Error("exec to a non-terminal stdout didn't request stderr")
+	}
+	if record.size != nil {
+		t.Errorf("exec to a non-terminal stdout got terminal size %+v", record.size)
+	}
+	if got := stdout.String(); got != "out\n" {
+		t.Errorf("stdout = %q, want %q", got, "out\n")
+	}
+	if got := stderr.String(); got != "err\n" {
+		t.Errorf("stderr = %q, want %q", got, "err\n")
+	}
+}
+
+func TestExecPodAutoTerminal(t *testing.T) {
+	c, execs := newTestClient(t, ClientOpt{})
+
+	out, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	size := remotecommand.TerminalSize{Width: 132, Height: 43}
+	fakeTerminal(t, int(out.Fd()), size)
+
+	var stderr bytes.Buffer
+	if err := c.ExecPodAuto([]string{"sh", "-c", "echo out; echo err >&2"}, nil, out, &stderr, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	record := execs.last(t)
+	if !record.tty {
+		t.Error("exec to a terminal stdout didn't allocate a TTY")
+	}
+	if record.stderr {
+		t.Error("exec with a TTY requested a separate stderr")
+	}
+	if record.size == nil || *record.size != size {
+		t.Errorf("terminal size = %+v, want %+v", record.size, size)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("stderr = %q, want it merged into stdout", stderr.String())
+	}
+	data, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(data); got != "out\nerr\n" {
+		t.Errorf("stdout = %q, want %q", got, "out\nerr\n")
+	}
+}
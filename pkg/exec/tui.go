@@ -0,0 +1,109 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+)
+
+// TUIEvent is an event sent by ExecPodTUIEvents: an OutputEvent, a
+// StatusEvent or an ExitEvent.
+type TUIEvent interface {
+	tuiEvent()
+}
+
+// OutputEvent is one complete line of output, without its newline.
+type OutputEvent struct {
+	// Stream is "stdout" or "stderr".
+	Stream string
+	Line   string
+}
+
+// StatusEvent reports that the command is "running" or "done".
+type StatusEvent struct {
+	Status string
+}
+
+// ExitEvent is the last event of a run. Code is the exit status, or -1 when
+// the command did not report one, in which case Err says what went wrong.
+type ExitEvent struct {
+	Code int
+	Err  error
+}
+
+func (OutputEvent) tuiEvent() {}
+func (StatusEvent) tuiEvent() {}
+func (ExitEvent) tuiEvent()   {}
+
+// ExecPodTUIEvents runs command and reports its progress as events suited to
+// a terminal UI: a "running" StatusEvent, an OutputEvent per line of stdout
+// and stderr, a "done" StatusEvent and finally an ExitEvent, after which the
+// channel is closed. Cancelling ctx tears the stream down and drops output
+// not yet taken; the ExitEvent then carries the error. Callers must keep
+// reading until the channel is closed.
+//
+// With bubbletea, for instance, a command can feed the events to the model
+// one at a time:
+//
+//	func waitForEvent(events <-chan exec.TUIEvent) tea.Cmd {
+//		return func() tea.Msg {
+//			return <-events
+//		}
+//	}
+//
+//	func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+//		switch msg := msg.(type) {
+//		case exec.OutputEvent:
+//			m.lines = append(m.lines, msg.Stream+": "+msg.Line)
+//		case exec.ExitEvent:
+//			m.code = msg.Code
+//			return m, nil
+//		}
+//		return m, waitForEvent(m.events)
+//	}
+func (c *Client) ExecPodTUIEvents(ctx context.Context, command []string) (<-chan TUIEvent, error) {
+	if len(command) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+
+	events := make(chan TUIEvent, 16)
+	send := func(event TUIEvent) error {
+		select {
+		case events <- event:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	output := func(stream string) *lineWriter {
+		return newLineWriter(func(line string) error {
+			return send(OutputEvent{Stream: stream, Line: line})
+		})
+	}
+
+	go func() {
+		defer close(events)
+		events <- StatusEvent{Status: "running"}
+
+		stdout, stderr := output("stdout"), output("stderr")
+		err := c.exec(ctx, execOptions{
+			command: command,
+			explain: true,
+			stdout:  stdout,
+			stderr:  stderr,
+		})
+		stdout.Flush()
+		stderr.Flush()
+
+		exit := ExitEvent{}
+		if err != nil {
+			exit.Code = -1
+			if code, ok := exitCode(err); ok {
+				exit.Code = code
+			}
+			exit.Err = err
+		}
+		events <- StatusEvent{Status: "done"}
+		events <- exit
+	}()
+	return events, nil
+}
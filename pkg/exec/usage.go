@@ -0,0 +1,114 @@
+package exec
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeBinaries are where ExecPodWithUsage looks for time(1). The shell's
+// time keyword can't be used, as it doesn't report memory usage.
+var timeBinaries = []string{"/usr/bin/time", "/bin/time"}
+
+// timeVerboseHeader starts the report of time -v appended to stderr.
+const timeVerboseHeader = "\tCommand being timed:"
+
+// ResourceUsage is what a command run by ExecPodWithUsage cost.
+type ResourceUsage struct {
+	// MaxRSSKB is the maximum resident set size in kilobytes.
+	MaxRSSKB   int64
+	UserTime   time.Duration
+	SystemTime time.Duration
+	WallTime   time.Duration
+	// Measured is false when the container has no time(1) supporting -v. Only
+	// WallTime is set then, measured locally, so it includes the exec's
+	// round trips.
+	Measured bool
+}
+
+// verboseTime returns a time(1) in the container that supports -v, GNU time
+// or a busybox build with it, or "" if there is none.
+func (c *Client) verboseTime(ctx context.Context) string {
+	for _, bin := range timeBinaries {
+		_, stderr, err := c.capture(ctx, []string{bin, "-v", "true"}, binaryCheckTimeout)
+		if err == nil && strings.Contains(stderr, "Maximum resident set size") {
+			return bin
+		}
+	}
+	return ""
+}
+
+// parseTimeReport parses the time -v report into usage.
+func parseTimeReport(report string) ResourceUsage {
+	usage := ResourceUsage{Measured: true}
+	for _, line := range strings.Split(report, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), ": ")
+		if !ok {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(key, "Maximum resident set size"):
+			usage.MaxRSSKB, _ = strconv.ParseInt(value, 10, 64)
+		case strings.HasPrefix(key, "User time"):
+			usage.UserTime = parseSeconds(value)
+		case strings.HasPrefix(key, "System time"):
+			usage.SystemTime = parseSeconds(value)
+		case strings.HasPrefix(key, "Elapsed (wall clock) time"):
+			usage.WallTime = parseClock(value)
+		}
+	}
+	return usage
+}
+
+func parseSeconds(value string) time.Duration {
+	seconds, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// parseClock parses an elapsed time given as [h:]m:ss[.ss].
+func parseClock(value string) time.Duration {
+	parts := strings.Split(value, ":")
+	minutes := 0
+	for _, part := range parts[:len(parts)-1] {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return 0
+		}
+		minutes = minutes*60 + n
+	}
+	return time.Duration(minutes)*time.Minute + parseSeconds(parts[len(parts)-1])
+}
+
+// ExecPodWithUsage runs command under time -v and returns its stdout along
+// with the CPU time, wall time and peak memory it used, e.g. to learn what a
+// diagnostic command costs. When the container has no suitable time(1), the
+// command runs as is and only a locally measured wall time is reported, with
+// usage.Measured false.
+func (c *Client) ExecPodWithUsage(command []string, timeout time.Duration) (output string, usage ResourceUsage, err error) {
+	ctx := context.Background()
+
+	bin := c.verboseTime(ctx)
+	if bin == "" {
+		start := time.Now()
+		stdout, stderr, err := c.captureExplained(ctx, command, timeout)
+		usage = ResourceUsage{WallTime: time.Since(start)}
+		if err != nil {
+			return stdout, usage, withStderr(err, stderr)
+		}
+		return stdout, usage, nil
+	}
+
+	stdout, stderr, err := c.captureExplained(ctx, append([]string{bin, "-v"}, command...), timeout)
+	if i := strings.LastIndex(stderr, timeVerboseHeader); i >= 0 {
+		usage = parseTimeReport(stderr[i:])
+		stderr = stderr[:i]
+	}
+	if err != nil {
+		return stdout, usage, withStderr(err, stderr)
+	}
+	return stdout, usage, nil
+}
@@ -0,0 +1,128 @@
+package exec
+
+import (
+	"context"
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/pingcap/log"
+	"go.uber.org/zap"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+)
+
+// ErrWatchdogKilled is returned by ExecPodWatchdog when the command was still
+// running at its hard limit.
+var ErrWatchdogKilled = fmt.Errorf("command killed by watchdog")
+
+// watchdogStderrBytes is how much of the end of stderr ExecPodWatchdog keeps
+// when MaxCaptureBytes is not set.
+const watchdogStderrBytes = 64 << 10
+
+// watchdogCommand wraps command so it records its PID in pidFile before
+// replacing the shell, leaving the PID unchanged.
+func watchdogCommand(pidFile string, command []string) []string {
+	return shellCommand("echo $$ >" + shellQuote(pidFile) + " && exec " + shellJoin(command))
+}
+
+// signalWatchdog sends signal to the process recorded in pidFile and, where
+// pkill is available, to its direct children. The watched exec holds a stream
+// slot until it ends, so the signal exec doesn't wait for one.
+func (c *Client) signalWatchdog(pidFile string, signal syscall.Signal) error {
+	script := fmt.Sprintf(`pid=$(cat %s) || exit 1; if command -v pkill >/dev/null 2>&1; then pkill -%d -P "$pid"; fi; kill -%d "$pid"`,
+		shellQuote(pidFile), int(signal), int(signal))
+	_, stderr, err := c.captureWith(context.Background(), execOptions{
+		command:   shellCommand(script),
+		timeout:   processProbeTimeout,
+		unlimited: true,
+	})
+	if err != nil {
+		return withStderr(err, stderr)
+	}
+	return nil
+}
+
+// ExecPodWatchdog runs command with graceful-then-forceful termination for
+// diagnostics that might hang. At softLimit the command is sent SIGTERM from
+// a companion exec; if it is still running at hardLimit it is sent SIGKILL
+// and the stream is torn down, returning ErrWatchdogKilled.
+//
+// The command is identified by a PID file under /tmp, so the container needs
+// sh, cat and a writable /tmp, and the command runs under the exec'ing shell's
+// PID rather than as its child. Where pkill is available the signals also
+// reach the command's direct children; without it only the command itself is
+// signaled, and children that don't exit with it may keep running.
+//
+// Errors carry the end of the command's stderr, at most MaxCaptureBytes or,
+// if that is not set, 64KiB, so a command flooding stderr can't exhaust
+// memory.
+//
+// The companion signal execs bypass MaxConcurrentStreams and StreamLimiter,
+// so while one runs the client has a stream open beyond those limits.
+func (c *Client) ExecPodWatchdog(command []string, softLimit, hardLimit time.Duration) error {
+	if len(command) == 0 {
+		return fmt.Errorf("empty command")
+	}
+	if softLimit <= 0 || hardLimit <= softLimit {
+		return fmt.Errorf("invalid watchdog limits: soft %s, hard %s", softLimit, hardLimit)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pidFile := "/tmp/k8sutils-watchdog-" + utilrand.String(10)
+
+	defer func() {
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+		defer cancel()
+		if _, rmStderr, rmErr := c.capture(cleanupCtx, []string{"rm", "-f", pidFile}, cleanupTimeout); rmErr != nil {
+			log.Warn("failed to remove watchdog PID file", zap.String("path", pidFile), zap.Error(withStderr(rmErr, rmStderr)))
+		}
+	}()
+
+	stderrSize := c.MaxCaptureBytes
+	if stderrSize <= 0 {
+		stderrSize = watchdogStderrBytes
+	}
+	stderr := RingCapture(stderrSize)
+	done := make(chan error, 1)
+	go func() {
+		done <- c.exec(ctx, execOptions{
+			command: watchdogCommand(pidFile, command),
+			explain: true,
+			stderr:  stderr,
+		})
+	}()
+
+	soft := time.NewTimer(softLimit)
+	defer soft.Stop()
+	hard := time.NewTimer(hardLimit)
+	defer hard.Stop()
+
+	terminated := false
+	for {
+		select {
+		case err := <-done:
+			if err != nil && terminated {
+				return fmt.Errorf("command terminated after soft limit %s: %w", softLimit, withStderr(err, string(stderr.Bytes())))
+			}
+			if err != nil {
+				return withStderr(err, string(stderr.Bytes()))
+			}
+			return nil
+		case <-soft.C:
+			terminated = true
+			log.Warn("command reached watchdog soft limit, sending SIGTERM", zap.Strings("command", command), zap.Duration("limit", softLimit))
+			if err := c.signalWatchdog(pidFile, syscall.SIGTERM); err != nil {
+				log.Warn("failed to send SIGTERM", zap.Strings("command", command), zap.Error(err))
+			}
+		case <-hard.C:
+			log.Warn("command reached watchdog hard limit, sending SIGKILL", zap.Strings("command", command), zap.Duration("limit", hardLimit))
+			if err := c.signalWatchdog(pidFile, syscall.SIGKILL); err != nil {
+				log.Warn("failed to send SIGKILL", zap.Strings("command", command), zap.Error(err))
+			}
+			cancel()
+			<-done
+			return fmt.Errorf("%w after %s", ErrWatchdogKilled, hardLimit)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package exec
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecPodWatchdogAtStreamLimit(t *testing.T) {
+	failFast, err := NewStreamLimiter(1, StreamLimitFailFast)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, opt := range map[string]ClientOpt{
+		"MaxConcurrentStreams": {MaxConcurrentStreams: 1},
+		"fail-fast limiter":    {StreamLimiter: failFast},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, _ := newTestClient(t, opt)
+			start := time.Now()
+			err := c.ExecPodWatchdog([]string{"sleep", "30"}, 200*time.Millisecond, 20*time.Second)
+			if err == nil || errors.Is(err, ErrWatchdogKilled) {
+				t.Fatalf("ExecPodWatchdog = %v, want the command terminated by SIGTERM", err)
+			}
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("ExecPodWatchdog took %s, want SIGTERM at the soft limit", elapsed)
+			}
+		})
+	}
+}
+
+func TestExecPodWatchdogBoundsStderr(t *testing.T) {
+	c, _ := newTestClient(t, ClientOpt{MaxCaptureBytes: 1024})
+	script := `head -c 1000000 /dev/zero | tr '\0' x >&2; echo tail >&2; exit 1`
+	err := c.ExecPodWatchdog([]string{"sh", "-c", script}, 10*time.Second, 20*time.Second)
+	if err == nil {
+		t.Fatal("ExecPodWatchdog of failing command succeeded")
+	}
+	if msg := err.Error(); len(msg) > 2048 || !strings.HasSuffix(msg, "tail") {
+		t.Errorf("ExecPodWatchdog error has %d bytes, want the bounded end of stderr ending in tail", len(msg))
+	}
+}
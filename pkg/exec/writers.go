@@ -0,0 +1,235 @@
+package exec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"unicode/utf8"
+)
+
+// RingBuffer is an io.Writer that keeps only the last bytes written to it.
+// It is safe for concurrent use, so stdout and stderr can share one.
+type RingBuffer struct {
+	mu   sync.Mutex
+	buf  []byte
+	size int
+	// start is the index of the oldest byte once the buffer is full.
+	start int
+	full  bool
+}
+
+// RingCapture returns a RingBuffer holding at most maxBytes bytes. It suits
+// chatty, long-running commands where only the most recent output matters.
+func RingCapture(maxBytes int) *RingBuffer {
+	if maxBytes < 0 {
+		maxBytes = 0
+	}
+	return &RingBuffer{buf: make([]byte, 0, maxBytes), size: maxBytes}
+}
+
+// Write implements io.Writer. It never fails; older bytes are dropped.
+func (r *RingBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := len(p)
+	if r.size == 0 {
+		return n, nil
+	}
+	if len(p) >= r.size {
+		r.buf = append(r.buf[:0], p[len(p)-r.size:]...)
+		r.start, r.full = 0, true
+		return n, nil
+	}
+
+	if !r.full {
+		room := r.size - len(r.buf)
+		if len(p) <= room {
+			r.buf = append(r.buf, p...)
+			r.full = len(r.buf) == r.size
+			return n, nil
+		}
+		r.buf = append(r.buf, p[:room]...)
+		p = p[room:]
+		r.full = true
+	}
+
+	for len(p) > 0 {
+		copied := copy(r.buf[r.start:], p)
+		p = p[copied:]
+		r.start = (r.start + copied) % r.size
+	}
+	return n, nil
+}
+
+// Bytes returns a copy of the retained bytes, oldest first.
+func (r *RingBuffer) Bytes() []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	out := make([]byte, 0, len(r.buf))
+	if !r.full {
+		return append(out, r.buf...)
+	}
+	out = append(out, r.buf[r.start:]...)
+	return append(out, r.buf[:r.start]...)
+}
+
+// Len returns the number of retained bytes.
+func (r *RingBuffer) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.buf)
+}
+
+// incompleteUTF8 returns how many bytes at the end of p are the start of a
+// multi-byte UTF-8 character whose remaining bytes have not arrived yet.
+func incompleteUTF8(p []byte) int {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(p[i]) {
+			if utf8.FullRune(p[i:]) {
+				return 0
+			}
+			return len(p) - i
+		}
+	}
+	return 0
+}
+
+// lineWriter is an io.Writer that calls fn for every complete line written to
+// it, without the trailing newline. Call Flush when the stream ends to hand
+// over a last line that has no newline. Since lines are only cut at newlines,
+// a multi-byte character split across writes always reaches fn whole.
+type lineWriter struct {
+	mu  sync.Mutex
+	buf []byte
+	fn  func(line string) error
+	err error
+}
+
+func newLineWriter(fn func(line string) error) *lineWriter {
+	return &lineWriter{fn: fn}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(bytes.TrimSuffix(w.buf[:i], []byte("\r")))
+		w.buf = w.buf[i+1:]
+		if err := w.fn(line); err != nil {
+			w.err = err
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush hands any buffered partial line to fn.
+func (w *lineWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil || len(w.buf) == 0 {
+		return w.err
+	}
+	line := string(w.buf)
+	w.buf = nil
+	w.err = w.fn(line)
+	return w.err
+}
+
+// MultiSink is an io.Writer that copies every write to a set of named sinks,
+// e.g. a terminal, a log file and a network forwarder. Unlike io.MultiWriter,
+// a failing sink doesn't fail the write: it is dropped and its error kept for
+// Err, while the other sinks keep receiving output. Sinks can be added and
+// removed while a stream is writing. The zero value is ready to use.
+type MultiSink struct {
+	mu    sync.Mutex
+	sinks []namedSink
+	errs  []error
+}
+
+type namedSink struct {
+	name string
+	w    io.Writer
+}
+
+// AddSink registers w under name, replacing any sink already registered under
+// that name. It receives writes from the next one on.
+func (m *MultiSink) AddSink(name string, w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.sinks {
+		if m.sinks[i].name == name {
+			m.sinks[i].w = w
+			return
+		}
+	}
+	m.sinks = append(m.sinks, namedSink{name: name, w: w})
+}
+
+// RemoveSink unregisters the sink registered under name and reports whether
+// there was one.
+func (m *MultiSink) RemoveSink(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.sinks {
+		if m.sinks[i].name == name {
+			m.sinks = append(m.sinks[:i], m.sinks[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Write implements io.Writer. It never fails.
+func (m *MultiSink) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	kept := m.sinks[:0]
+	for _, sink := range m.sinks {
+		n, err := sink.w.Write(p)
+		if err == nil && n < len(p) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			m.errs = append(m.errs, fmt.Errorf("sink %s: %w", sink.name, err))
+			continue
+		}
+		kept = append(kept, sink)
+	}
+	m.sinks = kept
+	return len(p), nil
+}
+
+// Err returns the errors of the sinks that were dropped because a write to
+// them failed, or nil.
+func (m *MultiSink) Err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return errors.Join(m.errs...)
+}
+
+// syncWriter serializes writes to w, so stdout and stderr can share it.
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
@@ -0,0 +1,194 @@
+package exec
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestRingBufferWraparound(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		writes []string
+		want   string
+	}{
+		{"empty", 4, nil, ""},
+		{"fits", 8, []string{"abc", "de"}, "abcde"},
+		{"exactly full", 4, []string{"ab", "cd"}, "abcd"},
+		{"wraps in the middle", 4, []string{"abc", "def"}, "cdef"},
+		{"wraps repeatedly", 4, []string{"ab", "cd", "ef", "gh", "i"}, "fghi"},
+		{"single byte writes", 3, strings.Split("abcdefg", ""), "efg"},
+		{"write larger than buffer", 4, []string{"ab", "cdefghij"}, "ghij"},
+		{"write after large write", 4, []string{"abcdefgh", "xy"}, "ghxy"},
+		{"write of buffer size after wrap", 4, []string{"abc", "defg", "h"}, "efgh"},
+		{"zero size", 0, []string{"abc"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := RingCapture(tt.size)
+			for _, w := range tt.writes {
+				n, err := r.Write([]byte(w))
+				if err != nil || n != len(w) {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			if got := string(r.Bytes()); got != tt.want {
+				t.Errorf("Bytes() = %q, want %q", got, tt.want)
+			}
+			if r.Len() != len(tt.want) {
+				t.Errorf("Len() = %d, want %d", r.Len(), len(tt.want))
+			}
+		})
+	}
+}
+
+// splitRune is "é" cut after its first byte.
+var splitRune = []string{"caf\xc3", "\xa9 au lait\n"}
+
+func TestLineWriterSplitRune(t *testing.T) {
+	var lines []string
+	w := newLineWriter(func(line string) error {
+		lines = append(lines, line)
+		return nil
+	})
+	for _, p := range splitRune {
+		if _, err := w.Write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 || lines[0] != "café au lait" {
+		t.Errorf("lines = %q, want [\"café au lait\"]", lines)
+	}
+}
+
+func TestCastWriterSplitRune(t *testing.T) {
+	var out bytes.Buffer
+	w := &castWriter{out: &out, start: time.Now()}
+	for _, p := range splitRune {
+		if _, err := w.Write([]byte(p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// An incomplete character at the very end is flushed, not dropped.
+	w.Write([]byte("\xe2\x82"))
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var event []interface{}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("invalid event %q: %v", line, err)
+		}
+		got = append(got, event[2].(string))
+	}
+	want := []string{"caf", "é au lait\n", "\ufffd\ufffd"}
+	if strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+}
+
+// testPrompter returns a prompter that answers every prompt with "y" after
+// a short quiet period, and the prompts it saw.
+func testPrompter(pattern *regexp.Regexp) (*prompter, func() []string) {
+	var (
+		mu      sync.Mutex
+		prompts []string
+	)
+	p := &prompter{
+		opts: PromptOptions{
+			Responder: func(text string) (string, bool) {
+				mu.Lock()
+				defer mu.Unlock()
+				prompts = append(prompts, text)
+				return "y", true
+			},
+			MaxRepeats:  defaultPromptRepeats,
+			QuietPeriod: 20 * time.Millisecond,
+		},
+		pattern: pattern,
+		cancel:  func() {},
+		answers: make(chan string, 16),
+	}
+	return p, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), prompts...)
+	}
+}
+
+func TestPromptWatcherSplitRune(t *testing.T) {
+	p, prompts := testPrompter(regexp.MustCompile(`Überschreiben`))
+	w := &promptWatcher{p: p}
+	for _, chunk := range []string{"Datei Überschreiben? (j/n) \xc3", "\xbc"} {
+		w.Write([]byte(chunk))
+	}
+	select {
+	case <-p.answers:
+	case <-time.After(5 * time.Second):
+		t.Fatal("prompt was not answered")
+	}
+	got := prompts()
+	for _, text := range got {
+		if !utf8.ValidString(text) {
+			t.Errorf("responder got %q, which is not valid UTF-8", text)
+		}
+	}
+	if len(got) != 1 || got[0] != "Datei Überschreiben? (j/n) ü" {
+		t.Errorf("prompts = %q, want the complete prompt once", got)
+	}
+}
+
+func TestPromptWatcherSplitLine(t *testing.T) {
+	p, prompts := testPrompter(defaultPromptPattern)
+	w := &promptWatcher{p: p}
+	for _, chunk := range []string{"Connecting to http:", "//example.com\n", "key:", " value\n"} {
+		w.Write([]byte(chunk))
+	}
+	time.Sleep(10 * p.opts.QuietPeriod)
+	if got := prompts(); len(got) != 0 {
+		t.Errorf("prompts = %q, want none for lines split across writes", got)
+	}
+
+	w.Write([]byte("Continue? "))
+	select {
+	case <-p.answers:
+	case <-time.After(5 * time.Second):
+		t.Fatal("prompt was not answered")
+	}
+	if got := prompts(); len(got) != 1 || got[0] != "Continue? " {
+		t.Errorf("prompts = %q, want the idle prompt once", got)
+	}
+}
+
+func TestExecPodWithPromptsSplitLine(t *testing.T) {
+	c, _ := newTestClient(t, ClientOpt{})
+	var prompts []string
+	var stdout bytes.Buffer
+	script := `printf 'key:'; sleep 0.01; echo ' value'; printf 'Continue? '; read answer; echo "got $answer"`
+	err := c.ExecPodWithPrompts([]string{"sh", "-c", script}, PromptOptions{
+		Responder: func(text string) (string, bool) {
+			prompts = append(prompts, text)
+			return "y", true
+		},
+	}, &stdout, nil, time.Minute)
+	if err != nil {
+		t.Fatalf("ExecPodWithPrompts = %v", err)
+	}
+	if len(prompts) != 1 || prompts[0] != "Continue? " {
+		t.Errorf("prompts = %q, want only the real prompt", prompts)
+	}
+	if !strings.Contains(stdout.String(), "got y") {
+		t.Errorf("stdout = %q, want the answer read by the command", stdout.String())
+	}
+}